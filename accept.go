@@ -0,0 +1,66 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb
+
+import (
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// acceptRange is a media range from an Accept header, such as "text/*".
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept parses the media ranges in an Accept header. Ranges that can't
+// be parsed are skipped.
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+	}
+	return ranges
+}
+
+// quality returns the quality the most specific of the ranges matching
+// mediaType gives it, or 0 if none match. exact is set if the match wasn't a
+// wildcard.
+func quality(ranges []acceptRange, mediaType string) (q float64, exact bool) {
+	mediaType = strings.ToLower(mediaType)
+	slash := strings.Index(mediaType, "/")
+	specificity := -1
+	for _, r := range ranges {
+		var s int
+		switch {
+		case r.mediaType == mediaType:
+			s = 2
+		case slash >= 0 && r.mediaType == mediaType[:slash]+"/*":
+			s = 1
+		case r.mediaType == "*/*":
+			s = 0
+		default:
+			continue
+		}
+		if s > specificity {
+			specificity, q = s, r.q
+		}
+	}
+	return q, specificity == 2
+}
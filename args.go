@@ -0,0 +1,143 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+/*
+An ArgParser converts a string captured by a url pattern into the value
+passed to a Target's argument.
+
+If the string can't be converted the parser returns an error and the request
+is aborted. When the error has a StatusCode() int method, such as an
+*ErrorResponse, that status code is used, otherwise it is a 404.
+
+	uweb.RegisterArgParser(uuid.UUID{}, func(s string) (interface{}, error) {
+		return uuid.Parse(s)
+	})
+
+	uweb.Get("^users/([0-9a-f-]+)/$", func(id uuid.UUID) string { ... })
+*/
+type ArgParser func(s string) (interface{}, error)
+
+var argParsers = make(map[reflect.Type]ArgParser)
+
+// RegisterArgParser registers the parser used for Target arguments with the
+// same type as example.
+//
+// Targets using the type must be added after the parser is registered.
+func RegisterArgParser(example interface{}, parser ArgParser) {
+	argParsers[reflect.TypeOf(example)] = parser
+}
+
+// argTypeSupported reports whether a url pattern capture can be converted
+// into a value of type t.
+func argTypeSupported(t reflect.Type) bool {
+	if _, ok := argParsers[t]; ok {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// parseArg converts a url pattern capture into a value of type t.
+func parseArg(t reflect.Type, s string) (reflect.Value, error) {
+	if parser, ok := argParsers[t]; ok {
+		v, err := parser(s)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if v == nil {
+			return reflect.Zero(t), nil
+		}
+		value := reflect.ValueOf(v)
+		if !value.Type().AssignableTo(t) {
+			panic(fmt.Sprintf("Arg parser for %s returned a %s", t, value.Type()))
+		}
+		return value, nil
+	}
+
+	value := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.String:
+		value.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return value, err
+		}
+		value.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, t.Bits())
+		if err != nil {
+			return value, err
+		}
+		value.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, t.Bits())
+		if err != nil {
+			return value, err
+		}
+		value.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, t.Bits())
+		if err != nil {
+			return value, err
+		}
+		value.SetFloat(f)
+	default:
+		return value, fmt.Errorf("unsupported argument type %s", t)
+	}
+	return value, nil
+}
+
+// mustParseArg is like parseArg but aborts the request if the capture can't
+// be converted.
+func mustParseArg(t reflect.Type, s string) reflect.Value {
+	value, err := parseArg(t, s)
+	if err != nil {
+		if code := errorCode(err, 404); code != 404 {
+			Abort(code, err.Error())
+		}
+		Abort(404, "Not Found")
+	}
+	return value
+}
+
+// errorCode returns the status code carried by err, or any error it wraps,
+// or def if it doesn't have one.
+func errorCode(err error, def int) int {
+	var e interface {
+		StatusCode() int
+	}
+	if errors.As(err, &e) {
+		return e.StatusCode()
+	}
+	return def
+}
+
+// parseDate accepts dates (2006-01-02) and RFC 3339 timestamps.
+func parseDate(s string) (interface{}, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func init() {
+	RegisterArgParser(time.Time{}, parseDate)
+}
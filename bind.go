@@ -0,0 +1,316 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// argIsBindable reports whether argType is a struct, or a pointer to a
+// struct, that can be filled in by bindRequest.
+func argIsBindable(argType reflect.Type) bool {
+	if argType.Kind() == reflect.Ptr {
+		argType = argType.Elem()
+	}
+	return argType.Kind() == reflect.Struct
+}
+
+// bindRequest creates a value of argType and sets its fields from the
+// request.
+//
+// A JSON body is decoded into the value, using the fields' "json" tags.
+// Fields with a "form" tag are set from a url-encoded or multipart body, and
+// fields with a "query" tag from the query string. Fields without a tag are
+// set from the body's fields when it is a form, or from the query string if
+// the body isn't JSON, matching their names ignoring case. Fields tagged with
+// `json:"-"` are left alone unless they have a "form" or "query" tag. Slices
+// are set from all the values with the name. Finally the fields are bound to
+// the named capture groups by bindStructParams, which take precedence over
+// the other values.
+//
+// If any of the values are malformed the request is aborted with a 400
+// error listing the fields. The value is then checked by its validate tags,
+// and the request aborted with a 422 error if any of the fields are invalid.
+func bindRequest(ctx *Context, argType reflect.Type) reflect.Value {
+	ptr := argType.Kind() == reflect.Ptr
+	if ptr {
+		argType = argType.Elem()
+	}
+	value := reflect.New(argType)
+
+	var fieldErrors []FieldError
+	mediaType, _, _ := mime.ParseMediaType(ctx.Request.Header.Get("Content-Type"))
+	isForm := mediaType == "application/x-www-form-urlencoded" || mediaType == "multipart/form-data"
+	isJSON := mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+	if isJSON {
+		if body := ctx.readBody(); len(body) > 0 {
+			fieldErrors = decodeJSON(body, value.Interface())
+		}
+	}
+	var form, untagged Values
+	if isForm {
+		form = ctx.Form()
+		untagged = form
+	} else if !isJSON {
+		untagged = ctx.Query()
+	}
+	fieldErrors = append(fieldErrors, bindStructValues(value.Elem(), form, ctx.Query(), untagged)...)
+	if len(fieldErrors) > 0 {
+		e := NewError(400, "Bad Request")
+		e.Fields = fieldErrors
+		panic(e)
+	}
+
+	bindStructParams(value.Elem(), ctx.Params)
+
+	// report the fields by the names used by the request
+	tags := []string{"query", "path", "form", "json"}
+	if isJSON {
+		tags = []string{"json", "query", "path"}
+	} else if isForm {
+		tags = []string{"form", "query", "path"}
+	}
+	if fieldErrors := validateValue(value, "", tags); len(fieldErrors) > 0 {
+		panic(validationError(fieldErrors))
+	}
+
+	if ptr {
+		return value
+	}
+	return value.Elem()
+}
+
+// readBody reads the whole request body, up to Config.MaxBodySize bytes. It
+// can be called more than once.
+func (c *Context) readBody() []byte {
+	if c.body == nil {
+		r := c.Request.Body
+		if Config.MaxBodySize > 0 {
+			r = http.MaxBytesReader(c.Writer, r, Config.MaxBodySize)
+		}
+		body, err := io.ReadAll(r)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				Abort(413, "Request Entity Too Large")
+			}
+			Abort(400, "Bad Request")
+		}
+		c.body = body
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return c.body
+}
+
+// decodeJSON decodes a JSON body into v, describing what's wrong with it if
+// it can't be decoded.
+func decodeJSON(body []byte, v interface{}) []FieldError {
+	err := json.Unmarshal(body, v)
+	if err == nil {
+		return nil
+	}
+	var typeError *json.UnmarshalTypeError
+	if errors.As(err, &typeError) {
+		return []FieldError{{Field: typeError.Field, Message: fmt.Sprintf("must be %s", jsonTypeName(typeError.Type))}}
+	}
+	return []FieldError{{Message: "malformed JSON: " + err.Error()}}
+}
+
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	}
+	return "an object"
+}
+
+// bindStructValues sets the fields of value from the form and query string.
+// Fields without a tag are set from the untagged values, which can be nil.
+func bindStructValues(value reflect.Value, form, query, untagged Values) []FieldError {
+	var fieldErrors []FieldError
+	structType := value.Type()
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue // unexported
+		}
+		formTag, hasForm := field.Tag.Lookup("form")
+		queryTag, hasQuery := field.Tag.Lookup("query")
+		_, hasPath := field.Tag.Lookup("path")
+		if field.Tag.Get("json") == "-" && !hasForm && !hasQuery {
+			continue
+		}
+		if !hasForm && !hasQuery && !hasPath && field.Anonymous && field.Type.Kind() == reflect.Struct {
+			fieldErrors = append(fieldErrors, bindStructValues(value.Field(i), form, query, untagged)...)
+			continue
+		}
+		if field.PkgPath != "" || formTag == "-" || queryTag == "-" {
+			continue
+		}
+
+		var name string
+		var values []string
+		switch {
+		case hasForm:
+			name, values = lookupValues(form, formTag, field.Name)
+		case hasQuery:
+			name, values = lookupValues(query, queryTag, field.Name)
+		case hasPath:
+			continue
+		default:
+			name, values = lookupValues(untagged, "", field.Name)
+		}
+		if len(values) == 0 {
+			continue
+		}
+		if err := setFieldValues(value.Field(i), values); err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: name, Message: err.Error()})
+		}
+	}
+	return fieldErrors
+}
+
+// lookupValues finds the values for tag, or if tag is empty the values for
+// the field's name ignoring case. An exact match is preferred, then the
+// lower case name, then the first other match in sorted order. It returns the
+// name the values were found under.
+func lookupValues(values Values, tag, fieldName string) (string, []string) {
+	if tag != "" {
+		return tag, values[tag]
+	}
+	for _, name := range []string{fieldName, strings.ToLower(fieldName)} {
+		if v, ok := values[name]; ok {
+			return name, v
+		}
+	}
+	found := ""
+	for name := range values {
+		if strings.EqualFold(name, fieldName) && (found == "" || name < found) {
+			found = name
+		}
+	}
+	if found == "" {
+		return "", nil
+	}
+	return found, values[found]
+}
+
+// setFieldValues sets a field from the values for it in a form or query
+// string. Fields of unsupported types are left alone.
+func setFieldValues(field reflect.Value, values []string) error {
+	t := field.Type()
+	if t.Kind() == reflect.Slice && argTypeSupported(t.Elem()) {
+		slice := reflect.MakeSlice(t, len(values), len(values))
+		for i, s := range values {
+			v, err := parseFieldValue(t.Elem(), s)
+			if err != nil {
+				return err
+			}
+			slice.Index(i).Set(v)
+		}
+		field.Set(slice)
+		return nil
+	}
+	if !argTypeSupported(t) {
+		return nil
+	}
+	v, err := parseFieldValue(t, values[0])
+	if err != nil {
+		return err
+	}
+	field.Set(v)
+	return nil
+}
+
+func parseFieldValue(t reflect.Type, s string) (reflect.Value, error) {
+	if t.Kind() == reflect.Bool {
+		// sent by checkboxes
+		switch strings.ToLower(s) {
+		case "on":
+			s = "true"
+		case "off":
+			s = "false"
+		}
+	}
+	v, err := parseArg(t, s)
+	if err != nil {
+		return v, fmt.Errorf("invalid value %q", s)
+	}
+	return v, nil
+}
+
+// bindStructParams sets the fields of value from the named capture groups in
+// params.
+//
+// A field is set from the group named by its "path" tag, or if it has no tag
+// the group with the same name as the field, ignoring case. Fields tagged
+// with "-" are skipped. The fields of embedded structs are bound as well.
+func bindStructParams(value reflect.Value, params map[string]string) {
+	structType := value.Type()
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue // unexported
+		}
+		name := field.Tag.Get("path")
+		if name == "-" {
+			continue
+		}
+		if name == "" && field.Anonymous && field.Type.Kind() == reflect.Struct {
+			bindStructParams(value.Field(i), params)
+			continue
+		}
+		if field.PkgPath != "" || !argTypeSupported(field.Type) {
+			continue
+		}
+
+		s, ok := lookupParam(params, name, field.Name)
+		if !ok {
+			continue
+		}
+		value.Field(i).Set(mustParseArg(field.Type, s))
+	}
+}
+
+// lookupParam finds the value for tag, or if tag is empty the value for the
+// field's name ignoring case. An exact match is preferred, then the lower
+// case name, then the first other match in sorted order.
+func lookupParam(params map[string]string, tag, fieldName string) (string, bool) {
+	if tag != "" {
+		s, ok := params[tag]
+		return s, ok
+	}
+	for _, name := range []string{fieldName, strings.ToLower(fieldName)} {
+		if s, ok := params[name]; ok {
+			return s, true
+		}
+	}
+	found := ""
+	for name := range params {
+		if strings.EqualFold(name, fieldName) && (found == "" || name < found) {
+			found = name
+		}
+	}
+	if found == "" {
+		return "", false
+	}
+	return params[found], true
+}
@@ -0,0 +1,174 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb_test
+
+import (
+	"fmt"
+	"github.com/calebbrown/uweb"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type createUser struct {
+	GroupID int    `path:"group"`
+	Name    string `json:"name" form:"name"`
+	Email   string `json:"email" form:"email"`
+	Tags    []string
+	Admin   bool `json:"-" form:"-"`
+	Notify  bool `json:"notify" form:"notify"`
+	DryRun  bool `query:"dry_run"`
+}
+
+type listUsers struct {
+	Page  int
+	Sizes []int `query:"size"`
+}
+
+// bindFieldErrors holds the fields listed by the last 400 error from the
+// bind/ routes.
+var bindFieldErrors []uweb.FieldError
+
+func init() {
+	bindApp := uweb.NewApp()
+	app.Mount("^bind/", bindApp)
+	bindApp.Post("^groups/(?P<group>[0-9]+)/users/$", func(in createUser) string {
+		return fmt.Sprintf("%+v", in)
+	})
+	bindApp.Get("^users/$", func(in *listUsers, again listUsers) string {
+		return fmt.Sprintf("%+v %+v", *in, again)
+	})
+	bindApp.Put("^users/$", func(first, second createUser) string {
+		return first.Name + " " + second.Name
+	})
+	bindApp.Error(400, func(e *uweb.ErrorResponse) string {
+		bindFieldErrors = e.Fields
+		return "bad request"
+	})
+}
+
+func TestBindJSON(t *testing.T) {
+	out := serve(app, "POST", "/bind/groups/7/users/?dry_run=1",
+		strings.NewReader(`{"name": "Bob", "email": "bob@example.com", "tags": ["a"], "Admin": true, "notify": true, "group": 9}`),
+		map[string]string{"Content-Type": "application/json; charset=utf-8"})
+	expected := "{GroupID:7 Name:Bob Email:bob@example.com Tags:[a] Admin:false Notify:true DryRun:true}"
+	if out.Code != 200 || out.Body.String() != expected {
+		t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+	}
+
+	// the body can be bound to more than one argument
+	out = serve(app, "PUT", "/bind/users/", strings.NewReader(`{"name": "Bob"}`),
+		map[string]string{"Content-Type": "application/vnd.api+json"})
+	if out.Code != 200 || out.Body.String() != "Bob Bob" {
+		t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+	}
+}
+
+func TestBindForm(t *testing.T) {
+	out := serve(app, "POST", "/bind/groups/7/users/",
+		strings.NewReader("name=Bob&email=bob%40example.com&tags=a&TAGS=b&admin=on&notify=on"),
+		map[string]string{"Content-Type": "application/x-www-form-urlencoded"})
+	expected := "{GroupID:7 Name:Bob Email:bob@example.com Tags:[a] Admin:false Notify:true DryRun:false}"
+	if out.Code != 200 || out.Body.String() != expected {
+		t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+	}
+}
+
+func TestBindQuery(t *testing.T) {
+	out := doSimpleRequest("GET", "/bind/users/?page=3&size=10&size=20", nil)
+	expected := "{Page:3 Sizes:[10 20]} {Page:3 Sizes:[10 20]}"
+	if out.Code != 200 || out.Body.String() != expected {
+		t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+	}
+
+	out = doSimpleRequest("GET", "/bind/users/", nil)
+	if out.Code != 200 || out.Body.String() != "{Page:0 Sizes:[]} {Page:0 Sizes:[]}" {
+		t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+	}
+}
+
+func TestBindErrors(t *testing.T) {
+	tests := []struct {
+		method, url, contentType, body string
+		fields                         []uweb.FieldError
+	}{
+		{"GET", "/bind/users/?page=x&size=1&size=y", "", "", []uweb.FieldError{
+			{Field: "page", Message: `invalid value "x"`},
+			{Field: "size", Message: `invalid value "y"`},
+		}},
+		{"POST", "/bind/groups/1/users/?dry_run=maybe", "application/x-www-form-urlencoded", "notify=2", []uweb.FieldError{
+			{Field: "notify", Message: `invalid value "2"`},
+			{Field: "dry_run", Message: `invalid value "maybe"`},
+		}},
+		{"POST", "/bind/groups/1/users/", "application/json", `{"name": 1}`, []uweb.FieldError{
+			{Field: "name", Message: "must be a string"},
+		}},
+		{"POST", "/bind/groups/1/users/", "application/json", `{"name": `, []uweb.FieldError{
+			{Message: "malformed JSON: unexpected end of JSON input"},
+		}},
+	}
+	for _, test := range tests {
+		bindFieldErrors = nil
+		out := serve(app, test.method, test.url, strings.NewReader(test.body),
+			map[string]string{"Content-Type": test.contentType})
+		if out.Code != 400 || out.Body.String() != "bad request" {
+			t.Errorf("%s %s: unexpected response %d %s", test.method, test.url, out.Code, out.Body.String())
+		}
+		if !reflect.DeepEqual(bindFieldErrors, test.fields) {
+			t.Errorf("%s %s: unexpected field errors %v", test.method, test.url, bindFieldErrors)
+		}
+	}
+}
+
+func TestBindErrorPage(t *testing.T) {
+	a := uweb.NewApp()
+	a.Get("^users/$", func(in listUsers) string { return "" })
+
+	out := serve(a, "GET", "/users/?page=<x>", nil, nil)
+	if out.Code != 400 || !strings.Contains(out.Body.String(), `<li>page: invalid value &#34;&lt;x&gt;&#34;</li>`) {
+		t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+	}
+}
+
+type updateProfile struct {
+	Name    string `json:"name"`
+	Tags    []string
+	IsAdmin bool `json:"-"`
+}
+
+func TestBindIgnoresExcludedFields(t *testing.T) {
+	a := uweb.NewApp()
+	a.Post("^profile/$", func(in updateProfile) string {
+		return fmt.Sprintf("%+v", in)
+	})
+
+	out := serve(a, "POST", "/profile/?isadmin=true&name=Mallory&tags=a", strings.NewReader(`{"name": "Bob"}`),
+		map[string]string{"Content-Type": "application/json"})
+	if out.Code != 200 || out.Body.String() != "{Name:Bob Tags:[] IsAdmin:false}" {
+		t.Errorf("Unexpected JSON response: %d %s", out.Code, out.Body.String())
+	}
+	out = serve(a, "POST", "/profile/", strings.NewReader("isadmin=on&name=Bob&tags=a"),
+		map[string]string{"Content-Type": "application/x-www-form-urlencoded"})
+	if out.Code != 200 || out.Body.String() != "{Name:Bob Tags:[a] IsAdmin:false}" {
+		t.Errorf("Unexpected form response: %d %s", out.Code, out.Body.String())
+	}
+}
+
+func TestBindBodySize(t *testing.T) {
+	uweb.Config.MaxBodySize = 10
+	defer func() {
+		uweb.Config.MaxBodySize = 10 << 20
+	}()
+
+	a := uweb.NewApp()
+	a.Post("^profile/$", func(in updateProfile) string { return in.Name })
+	header := map[string]string{"Content-Type": "application/json"}
+	if out := serve(a, "POST", "/profile/", strings.NewReader(`{"name": "Bob"}`), header); out.Code != 413 {
+		t.Errorf("Unexpected response to a large body: %d", out.Code)
+	}
+	if out := serve(a, "POST", "/profile/", strings.NewReader(`{}`), header); out.Code != 200 || out.Body.String() != "" {
+		t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+	}
+}
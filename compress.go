@@ -0,0 +1,328 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A Compressor wraps w in a writer that compresses what is written to it.
+// Closing the writer must flush any remaining data, but not close w.
+type Compressor func(w io.Writer) io.WriteCloser
+
+type compressorEntry struct {
+	encoding   string
+	compressor Compressor
+}
+
+var (
+	compressorsLock sync.RWMutex
+	// the compressors in order of preference
+	compressors []compressorEntry
+)
+
+/*
+RegisterCompressor adds a compressor for a content coding, such as "gzip",
+used by the Compress middleware. A compressor registered for an encoding that
+already has one replaces it.
+
+When a client accepts several encodings equally, the one registered last is
+preferred. There are compressors for gzip and deflate, so registering another
+one, such as brotli, makes it the first choice of clients that support it:
+
+	uweb.RegisterCompressor("br", func(w io.Writer) io.WriteCloser {
+		return brotli.NewWriter(w)
+	})
+*/
+func RegisterCompressor(encoding string, compressor Compressor) {
+	encoding = strings.ToLower(encoding)
+	compressorsLock.Lock()
+	defer compressorsLock.Unlock()
+	for i, entry := range compressors {
+		if entry.encoding == encoding {
+			compressors[i].compressor = compressor
+			return
+		}
+	}
+	compressors = append([]compressorEntry{{encoding, compressor}}, compressors...)
+}
+
+func init() {
+	// "deflate" in HTTP is the zlib format
+	RegisterCompressor("deflate", func(w io.Writer) io.WriteCloser {
+		return zlib.NewWriter(w)
+	})
+	RegisterCompressor("gzip", func(w io.Writer) io.WriteCloser {
+		return gzip.NewWriter(w)
+	})
+}
+
+// CompressOptions holds the settings for compressing responses.
+//
+// MinSize is the smallest response, in bytes, that is compressed. It
+// defaults to 1024. Streamed responses of an unknown length are always
+// compressed.
+//
+// ContentTypes lists the media types of the responses that are compressed,
+// which can contain wildcards as in "application/*+json". It defaults to
+// common text formats.
+type CompressOptions struct {
+	MinSize      int
+	ContentTypes []string
+}
+
+var defaultCompressTypes = []string{
+	"text/html", "text/plain", "text/css", "text/csv", "text/javascript", "text/xml",
+	"application/json", "application/*+json", "application/javascript",
+	"application/xml", "application/*+xml", "image/svg+xml",
+}
+
+/*
+Compress creates Middleware that compresses responses with the encoding the
+client prefers in its Accept-Encoding header, out of those registered with
+RegisterCompressor.
+
+Both responses with their Content in hand and streamed responses are
+compressed. Responses that already have a Content-Encoding aren't compressed
+again, and a strong ETag is made weak as the compressed content differs.
+
+	app.Use(uweb.Compress(nil))
+*/
+func Compress(options *CompressOptions) Middleware {
+	o := CompressOptions{}
+	if options != nil {
+		o = *options
+	}
+	if o.MinSize == 0 {
+		o.MinSize = 1024
+	}
+	if len(o.ContentTypes) == 0 {
+		o.ContentTypes = defaultCompressTypes
+	}
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx *Context) *Response {
+			if _, ok := ctx.Writer.(*compressWriter); ok {
+				// already compressed by the App this one is mounted in
+				return next.Handle(ctx)
+			}
+			c := &compression{options: o}
+			c.encoding, c.compressor = chooseCompressor(ctx.Request.Header.Get("Accept-Encoding"))
+			if ctx.Writer != nil {
+				ctx.Writer = &compressWriter{ResponseWriter: ctx.Writer, c: c}
+			}
+			resp := next.Handle(ctx)
+			if resp != nil && resp.Body == nil && !resp.written && !ctx.Response.written {
+				c.compressContent(resp)
+			}
+			return resp
+		})
+	}
+}
+
+// chooseCompressor returns the compressor for the encoding the client
+// prefers, or nil if it doesn't accept any of them.
+func chooseCompressor(acceptEncoding string) (string, Compressor) {
+	ranges := parseAccept(acceptEncoding)
+	compressorsLock.RLock()
+	defer compressorsLock.RUnlock()
+	var best compressorEntry
+	bestQ := 0.0
+	for _, entry := range compressors {
+		q, wildcard := 0.0, 0.0
+		for _, r := range ranges {
+			if r.mediaType == entry.encoding {
+				q = r.q
+				break
+			}
+			if r.mediaType == "*" {
+				q, wildcard = r.q, r.q
+			}
+		}
+		if q == 0 {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = entry, q
+		}
+	}
+	return best.encoding, best.compressor
+}
+
+// compression holds the encoding chosen for a request.
+type compression struct {
+	options    CompressOptions
+	encoding   string
+	compressor Compressor
+}
+
+// eligible reports whether a response with the headers h can be
+// compressed. length is the length of the content, or -1 if it is unknown.
+// As whether a response is compressed then depends on the Accept-Encoding
+// header it is added to the Vary header.
+func (c *compression) eligible(code int, h http.Header, length int) bool {
+	if code == 304 {
+		c.notModified(h)
+		return false
+	}
+	if code < 200 || code == 204 || code == 206 {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	if length >= 0 && length < c.options.MinSize {
+		return false
+	}
+	if !c.compressible(h.Get("Content-Type")) {
+		return false
+	}
+	addVary(h, "Accept-Encoding")
+	return true
+}
+
+// compressible reports whether content of the type can be compressed.
+func (c *compression) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range c.options.ContentTypes {
+		if ok, _ := path.Match(pattern, mediaType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified gives a 304 Not Modified response the same Vary header and
+// ETag as the response it stands for would have had. As the content isn't
+// known, that response is assumed to be compressed unless a Content-Type
+// shows it wouldn't have been.
+func (c *compression) notModified(h http.Header) {
+	if h.Get("Content-Encoding") != "" {
+		return
+	}
+	if ct := h.Get("Content-Type"); ct != "" && !c.compressible(ct) {
+		return
+	}
+	addVary(h, "Accept-Encoding")
+	if c.compressor != nil {
+		weakenETag(h)
+	}
+}
+
+// setHeaders updates the headers for the compressed content. Ranges can't
+// be requested as they would refer to the uncompressed content.
+func (c *compression) setHeaders(h http.Header) {
+	h.Set("Content-Encoding", c.encoding)
+	h.Del("Content-Length")
+	h.Del("Accept-Ranges")
+	weakenETag(h)
+}
+
+// weakenETag makes a strong ETag weak, as the compressed content is only
+// equivalent to the content it identifies.
+func weakenETag(h http.Header) {
+	if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		h.Set("ETag", "W/"+etag)
+	}
+}
+
+// compressContent compresses the Content of a response, so that its
+// Content-Length is that of the compressed content.
+func (c *compression) compressContent(resp *Response) {
+	if !c.eligible(resp.Code, resp.Header(), len(resp.Content)) || c.compressor == nil {
+		return
+	}
+	var b bytes.Buffer
+	w := c.compressor(&b)
+	if _, err := w.Write(resp.Content); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	c.setHeaders(resp.Header())
+	resp.Content = b.Bytes()
+}
+
+// compressWriter compresses what is written to the Context's Writer, if the
+// response can be compressed once its headers are written. It is closed
+// once the response has been written.
+type compressWriter struct {
+	http.ResponseWriter
+	c           *compression
+	w           io.WriteCloser
+	wroteHeader bool
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	h := w.Header()
+	length := -1
+	if cl := h.Get("Content-Length"); cl != "" {
+		if n, err := strconv.Atoi(cl); err == nil {
+			length = n
+		}
+	}
+	if w.c.eligible(code, h, length) && w.c.compressor != nil {
+		w.c.setHeaders(h)
+		w.w = w.c.compressor(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.w != nil {
+		return w.w.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, flushing the compressed data written so
+// far to the client.
+func (w *compressWriter) Flush() {
+	if f, ok := w.w.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, if the underlying writer does.
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the ResponseWriter doesn't support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
+// Close finishes the compressed content.
+func (w *compressWriter) Close() error {
+	if w.w == nil {
+		return nil
+	}
+	return w.w.Close()
+}
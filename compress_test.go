@@ -0,0 +1,175 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"github.com/calebbrown/uweb"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var largeContent = strings.Repeat("µweb compresses this. ", 100)
+
+// upperCompressor is a fake compressor that upper cases the content.
+type upperCompressor struct {
+	w io.Writer
+}
+
+func (w upperCompressor) Write(b []byte) (int, error) {
+	return w.w.Write(bytes.ToUpper(b))
+}
+
+func (w upperCompressor) Close() error {
+	return nil
+}
+
+func init() {
+	uweb.RegisterCompressor("x-upper", func(w io.Writer) io.WriteCloser {
+		return upperCompressor{w}
+	})
+
+	compressApp := uweb.NewApp()
+	app.Mount("^compress/", compressApp)
+	compressApp.Use(uweb.Compress(nil))
+	compressApp.Get("^large/$", func() string { return largeContent })
+	compressApp.Get("^small/$", func() string { return "small" })
+	compressApp.Get("^image/$", func(ctx *uweb.Context) string {
+		ctx.Response.Header().Set("Content-Type", "image/png")
+		return largeContent
+	})
+	compressApp.Get("^encoded/$", func(ctx *uweb.Context) string {
+		ctx.Response.Header().Set("Content-Encoding", "identity")
+		return largeContent
+	})
+	compressApp.Get("^etag/$", func(ctx *uweb.Context) string {
+		ctx.Response.Header().Set("ETag", `"v1"`)
+		return largeContent
+	})
+	compressApp.Get("^notmodified/$", func(ctx *uweb.Context) *uweb.Response {
+		ctx.Response.Header().Set("ETag", `"v1"`)
+		ctx.Response.Code = 304
+		return ctx.Response
+	})
+	compressApp.Static("^files/", fstest.MapFS{
+		"large.txt": &fstest.MapFile{Data: []byte(largeContent), ModTime: time.Unix(1e9, 0)},
+	})
+	compressApp.Get("^stream/$", func(ctx *uweb.Context) {
+		w := ctx.Stream()
+		for i := 0; i < 3; i++ {
+			fmt.Fprint(w, "chunk ")
+		}
+	})
+	compressApp.Get("^reader/$", func() io.Reader {
+		return strings.NewReader(largeContent)
+	})
+
+	sub := uweb.NewApp()
+	sub.Use(uweb.Compress(&uweb.CompressOptions{MinSize: 1}))
+	sub.Get("^$", func() string { return largeContent })
+	sub.Get("^stream/$", func(ctx *uweb.Context) {
+		fmt.Fprint(ctx.Stream(), largeContent)
+	})
+	compressApp.Mount("^sub/", sub)
+}
+
+func decompress(t *testing.T, encoding string, body []byte) string {
+	var r io.Reader = bytes.NewReader(body)
+	var err error
+	switch encoding {
+	case "gzip":
+		r, err = gzip.NewReader(r)
+	case "deflate":
+		r, err = zlib.NewReader(r)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestCompress(t *testing.T) {
+	tests := []struct {
+		url, acceptEncoding, encoding, vary, body string
+	}{
+		{"/compress/large/", "gzip, deflate", "gzip", "Accept-Encoding", largeContent},
+		{"/compress/large/", "gzip;q=0.5, deflate", "deflate", "Accept-Encoding", largeContent},
+		{"/compress/large/", "*", "x-upper", "Accept-Encoding", strings.ToUpper(largeContent)},
+		{"/compress/large/", "gzip, x-upper", "x-upper", "Accept-Encoding", strings.ToUpper(largeContent)},
+		{"/compress/large/", "gzip;q=0, deflate;q=0, x-upper;q=0", "", "Accept-Encoding", largeContent},
+		{"/compress/large/", "br", "", "Accept-Encoding", largeContent},
+		{"/compress/large/", "", "", "Accept-Encoding", largeContent},
+		{"/compress/small/", "gzip", "", "", "small"},
+		{"/compress/image/", "gzip", "", "", largeContent},
+		{"/compress/encoded/", "gzip", "identity", "", largeContent},
+		{"/compress/stream/", "gzip", "gzip", "Accept-Encoding", "chunk chunk chunk "},
+		{"/compress/reader/", "deflate", "deflate", "Accept-Encoding", largeContent},
+		{"/compress/sub/", "gzip", "gzip", "Accept-Encoding", largeContent},
+		{"/compress/sub/stream/", "gzip", "gzip", "Accept-Encoding", largeContent},
+	}
+	for _, test := range tests {
+		out := serve(app, "GET", test.url, nil, map[string]string{"Accept-Encoding": test.acceptEncoding})
+		h := out.Header()
+		if out.Code != 200 {
+			t.Errorf("%s %s: unexpected status code %d", test.url, test.acceptEncoding, out.Code)
+		}
+		if v := h.Get("Content-Encoding"); v != test.encoding {
+			t.Errorf("%s %s: unexpected encoding %q", test.url, test.acceptEncoding, v)
+		}
+		if v := strings.Join(h["Vary"], ", "); v != test.vary {
+			t.Errorf("%s %s: unexpected vary %q", test.url, test.acceptEncoding, v)
+		}
+		if cl := h.Get("Content-Length"); cl != "" && cl != strconv.Itoa(out.Body.Len()) {
+			t.Errorf("%s %s: content length %s of %d bytes", test.url, test.acceptEncoding, cl, out.Body.Len())
+		}
+		if body := decompress(t, test.encoding, out.Body.Bytes()); body != test.body {
+			t.Errorf("%s %s: unexpected body %q", test.url, test.acceptEncoding, body)
+		}
+	}
+
+	out := serve(app, "GET", "/compress/etag/", nil, map[string]string{"Accept-Encoding": "gzip"})
+	if etag := out.Header().Get("ETag"); etag != `W/"v1"` {
+		t.Errorf("ETag not made weak: %s", etag)
+	}
+	out = doSimpleRequest("GET", "/compress/etag/", nil)
+	if etag := out.Header().Get("ETag"); etag != `"v1"` {
+		t.Errorf("ETag changed without compression: %s", etag)
+	}
+
+	// a 304 has the same validators as the response it stands for
+	out = serve(app, "GET", "/compress/notmodified/", nil, map[string]string{"Accept-Encoding": "gzip"})
+	if out.Code != 304 || out.Header().Get("ETag") != `W/"v1"` || out.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("Unexpected 304 response: %d %v", out.Code, out.Header())
+	}
+	out = doSimpleRequest("GET", "/compress/notmodified/", nil)
+	if out.Header().Get("ETag") != `"v1"` || out.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("Unexpected 304 response without compression: %v", out.Header())
+	}
+
+	// ranges of compressed files aren't offered
+	out = serve(app, "GET", "/compress/files/large.txt", nil, map[string]string{"Accept-Encoding": "gzip"})
+	if out.Header().Get("Content-Encoding") != "gzip" || out.Header().Get("Accept-Ranges") != "" {
+		t.Errorf("Unexpected headers for a compressed file: %v", out.Header())
+	}
+	if body := decompress(t, "gzip", out.Body.Bytes()); body != largeContent {
+		t.Errorf("Unexpected file content: %q", body)
+	}
+	out = doSimpleRequest("GET", "/compress/files/large.txt", nil)
+	if out.Header().Get("Accept-Ranges") != "bytes" {
+		t.Errorf("Unexpected headers for an uncompressed file: %v", out.Header())
+	}
+}
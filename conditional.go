@@ -0,0 +1,201 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ETagOptions holds the settings for generating ETags.
+//
+// Weak makes the generated ETags weak, for content that is equivalent but
+// not always identical byte for byte.
+type ETagOptions struct {
+	Weak bool
+}
+
+/*
+ETags creates Middleware that gives successful responses to GET and HEAD
+requests an ETag computed from their Content, unless they already have one,
+and answers conditional requests with 304 Not Modified when the client's
+copy is still current.
+
+If-None-Match is checked against the ETag, and If-Modified-Since against the
+Last-Modified header if the response has one. A failed If-Match or
+If-Unmodified-Since precondition gets a 412 Precondition Failed error.
+Streamed responses are left alone.
+
+Use it after Compress so the ETag is computed from the uncompressed content:
+
+	app.Use(uweb.Compress(nil), uweb.ETags(nil))
+
+ETags does not check the preconditions of PUT, PATCH, DELETE or any other
+requests that change a resource. It only sees the response once the target
+has already made the change, so If-Match and If-Unmodified-Since headers on
+those requests are ignored unless the target checks them itself first with
+Context.CheckPreconditions.
+*/
+func ETags(options *ETagOptions) Middleware {
+	o := ETagOptions{}
+	if options != nil {
+		o = *options
+	}
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx *Context) *Response {
+			resp := next.Handle(ctx)
+			if resp == nil || resp.Code != 200 || resp.Body != nil || resp.written || ctx.Response.written {
+				return resp
+			}
+			if ctx.Method != "GET" && ctx.Method != "HEAD" {
+				return resp
+			}
+
+			h := resp.Header()
+			etag := h.Get("ETag")
+			if etag == "" {
+				etag = contentETag(resp.Content, o.Weak)
+				h.Set("ETag", etag)
+			}
+			lastModified, _ := http.ParseTime(h.Get("Last-Modified"))
+			switch checkPreconditions(ctx.Request, etag, lastModified) {
+			case 304:
+				notModified(resp)
+			case 412:
+				Abort(412, "Precondition Failed")
+			}
+			return resp
+		})
+	}
+}
+
+// contentETag returns an ETag for content.
+func contentETag(content []byte, weak bool) string {
+	sum := sha256.Sum256(content)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	if weak {
+		etag = "W/" + etag
+	}
+	return etag
+}
+
+/*
+CheckPreconditions checks the conditional headers of the request against the
+current ETag and modification time of the resource, either of which can be
+empty or zero if it isn't known.
+
+A request that changes the resource, such as PUT, PATCH or DELETE, is aborted
+with a 412 Precondition Failed error if its If-Match or If-Unmodified-Since
+precondition fails, or if its If-None-Match header matches. This stops a
+client overwriting changes it hasn't seen.
+
+For GET and HEAD requests the ETag and Last-Modified headers are set, and if
+the client's copy is still current the target is stopped and a 304 Not
+Modified response is sent.
+
+	func UpdateArticle(ctx *uweb.Context, id int, changes ArticleChanges) *Article {
+		article := findArticle(id)
+		ctx.CheckPreconditions(article.ETag(), article.Updated)
+		article.Update(changes)
+		return article
+	}
+*/
+func (c *Context) CheckPreconditions(etag string, lastModified time.Time) {
+	if c.Method == "GET" || c.Method == "HEAD" {
+		h := c.Response.Header()
+		if etag != "" {
+			h.Set("ETag", etag)
+		}
+		if !lastModified.IsZero() {
+			h.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+		}
+	}
+	switch checkPreconditions(c.Request, etag, lastModified) {
+	case 304:
+		notModified(c.Response)
+		panic(c.Response)
+	case 412:
+		Abort(412, "Precondition Failed")
+	}
+}
+
+// checkPreconditions evaluates the conditional headers of a request in the
+// order given by RFC 7232, returning 304 or 412 if the request shouldn't go
+// ahead, otherwise 0.
+func checkPreconditions(r *http.Request, etag string, lastModified time.Time) int {
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !etagListMatches(ifMatch, etag, false) {
+			return 412
+		}
+	} else if t, err := http.ParseTime(r.Header.Get("If-Unmodified-Since")); err == nil && !lastModified.IsZero() {
+		if lastModified.Truncate(time.Second).After(t) {
+			return 412
+		}
+	}
+
+	safe := r.Method == "GET" || r.Method == "HEAD"
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if etagListMatches(ifNoneMatch, etag, true) {
+			if safe {
+				return 304
+			}
+			return 412
+		}
+	} else if t, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && safe && !lastModified.IsZero() {
+		if !lastModified.Truncate(time.Second).After(t) {
+			return 304
+		}
+	}
+	return 0
+}
+
+// etagListMatches reports whether etag is in the list of ETags in an If-Match
+// or If-None-Match header. The weak comparison ignores whether the ETags are
+// weak, the strong one requires both to be strong. "*" matches any ETag.
+func etagListMatches(header, etag string, weak bool) bool {
+	if etag == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for header != "" {
+		header = strings.TrimLeft(header, " \t,")
+		candidate := header
+		if strings.HasPrefix(header, "W/") {
+			header = header[2:]
+		}
+		if !strings.HasPrefix(header, `"`) {
+			return false
+		}
+		end := strings.Index(header[1:], `"`)
+		if end < 0 {
+			return false
+		}
+		header = header[end+2:]
+		candidate = candidate[:len(candidate)-len(header)]
+
+		if weak && strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+		if !weak && candidate == etag && !strings.HasPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified turns a response into a 304 Not Modified response, keeping
+// the headers that describe the content the client already has.
+func notModified(resp *Response) {
+	resp.Code = 304
+	resp.Content = nil
+	h := resp.Header()
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+}
@@ -0,0 +1,163 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb_test
+
+import (
+	"fmt"
+	"github.com/calebbrown/uweb"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var articleModified = time.Date(2020, 5, 6, 7, 8, 9, 0, time.UTC)
+
+// etagOptions are used by the ETags middleware of the conditional/ routes.
+var etagOptions *uweb.ETagOptions
+
+func init() {
+	conditionalApp := uweb.NewApp()
+	app.Mount("^conditional/", conditionalApp)
+	conditionalApp.Use(uweb.Compress(nil), func(next uweb.Handler) uweb.Handler {
+		return uweb.HandlerFunc(func(ctx *uweb.Context) *uweb.Response {
+			return uweb.ETags(etagOptions)(next).Handle(ctx)
+		})
+	})
+	conditionalApp.Get("^items/$", func() string { return "items" })
+	conditionalApp.Post("^items/$", func() string { return "created" })
+	conditionalApp.Get("^large/$", func() string { return largeContent })
+	conditionalApp.Get("^dated/$", func(ctx *uweb.Context) string {
+		ctx.Response.Header().Set("Last-Modified", articleModified.Format(http.TimeFormat))
+		return "dated"
+	})
+	conditionalApp.Get("^stream/$", func(ctx *uweb.Context) {
+		fmt.Fprint(ctx.Stream(), "streamed")
+	})
+
+	article := func(ctx *uweb.Context) string {
+		ctx.CheckPreconditions(`"article-1"`, articleModified)
+		return "article " + ctx.Method
+	}
+	conditionalApp.Get("^article/$", article)
+	conditionalApp.Put("^article/$", article)
+	conditionalApp.Delete("^article/$", article)
+}
+
+func TestETags(t *testing.T) {
+	etagOptions = nil
+
+	out := doSimpleRequest("GET", "/conditional/items/", nil)
+	etag := out.Header().Get("ETag")
+	if len(etag) != 34 || etag[0] != '"' {
+		t.Fatalf("Unexpected ETag: %s", etag)
+	}
+	if again := doSimpleRequest("GET", "/conditional/items/", nil); again.Header().Get("ETag") != etag {
+		t.Error("ETag not stable")
+	}
+
+	tests := []struct {
+		method, url string
+		header      map[string]string
+		code        int
+		body        string
+	}{
+		{"GET", "/conditional/items/", map[string]string{"If-None-Match": etag}, 304, ""},
+		{"HEAD", "/conditional/items/", map[string]string{"If-None-Match": etag}, 304, ""},
+		{"GET", "/conditional/items/", map[string]string{"If-None-Match": `"other", W/` + etag}, 304, ""},
+		{"GET", "/conditional/items/", map[string]string{"If-None-Match": "*"}, 304, ""},
+		{"GET", "/conditional/items/", map[string]string{"If-None-Match": `"other"`}, 200, "items"},
+		{"GET", "/conditional/items/", map[string]string{"If-Match": etag}, 200, "items"},
+		{"GET", "/conditional/items/", map[string]string{"If-Match": `"other"`}, 412, ""},
+		{"POST", "/conditional/items/", map[string]string{"If-None-Match": "*"}, 200, "created"},
+		{"GET", "/conditional/dated/", map[string]string{"If-Modified-Since": articleModified.Format(http.TimeFormat)}, 304, ""},
+		{"GET", "/conditional/dated/", map[string]string{"If-Modified-Since": articleModified.Add(-time.Hour).Format(http.TimeFormat)}, 200, "dated"},
+		{"GET", "/conditional/stream/", map[string]string{"If-None-Match": "*"}, 200, "streamed"},
+	}
+	for _, test := range tests {
+		out := serve(app, test.method, test.url, nil, test.header)
+		if out.Code != test.code {
+			t.Errorf("%s %s %v: unexpected status code %d", test.method, test.url, test.header, out.Code)
+		}
+		if test.code != 412 && out.Body.String() != test.body {
+			t.Errorf("%s %s %v: unexpected body %q", test.method, test.url, test.header, out.Body.String())
+		}
+		if test.code == 304 {
+			h := out.Header()
+			if h.Get("ETag") == "" || h.Get("Content-Type") != "" || h.Get("Content-Length") != "" {
+				t.Errorf("%s %s %v: unexpected headers %v", test.method, test.url, test.header, h)
+			}
+		}
+	}
+
+	if out := doSimpleRequest("POST", "/conditional/items/", nil); out.Header().Get("ETag") != "" {
+		t.Error("ETag set for a POST request")
+	}
+	if out := doSimpleRequest("GET", "/conditional/stream/", nil); out.Header().Get("ETag") != "" {
+		t.Error("ETag set for a streamed response")
+	}
+}
+
+func TestETagsWithCompression(t *testing.T) {
+	for _, weak := range []bool{false, true} {
+		etagOptions = &uweb.ETagOptions{Weak: weak}
+		plain := doSimpleRequest("GET", "/conditional/large/", nil).Header().Get("ETag")
+		compressed := serve(app, "GET", "/conditional/large/", nil, map[string]string{"Accept-Encoding": "gzip"})
+		etag := compressed.Header().Get("ETag")
+		if etag[:2] != "W/" || (weak && etag != plain) || (!weak && etag != "W/"+plain) {
+			t.Errorf("Unexpected ETags %s and %s", plain, etag)
+		}
+
+		out := serve(app, "GET", "/conditional/large/", nil, map[string]string{
+			"Accept-Encoding": "gzip",
+			"If-None-Match":   etag,
+		})
+		if out.Code != 304 || out.Body.Len() != 0 {
+			t.Errorf("Unexpected response: %d %d bytes", out.Code, out.Body.Len())
+		}
+	}
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	etagOptions = nil
+	modified := articleModified.Format(http.TimeFormat)
+	earlier := articleModified.Add(-time.Hour).Format(http.TimeFormat)
+
+	tests := []struct {
+		method string
+		header map[string]string
+		code   int
+	}{
+		{"PUT", nil, 200},
+		{"PUT", map[string]string{"If-Match": `"article-1"`}, 200},
+		{"PUT", map[string]string{"If-Match": `"other", "article-1"`}, 200},
+		{"PUT", map[string]string{"If-Match": "*"}, 200},
+		{"PUT", map[string]string{"If-Match": `"article-0"`}, 412},
+		{"PUT", map[string]string{"If-Match": `W/"article-1"`}, 412},
+		{"DELETE", map[string]string{"If-Unmodified-Since": modified}, 200},
+		{"DELETE", map[string]string{"If-Unmodified-Since": earlier}, 412},
+		{"PUT", map[string]string{"If-Match": `"article-1"`, "If-Unmodified-Since": earlier}, 200},
+		{"PUT", map[string]string{"If-None-Match": "*"}, 412},
+		{"GET", map[string]string{"If-None-Match": `"article-1"`}, 304},
+		{"GET", map[string]string{"If-Modified-Since": modified}, 304},
+		{"GET", map[string]string{"If-Modified-Since": earlier}, 200},
+	}
+	for _, test := range tests {
+		out := serve(app, test.method, "/conditional/article/", nil, test.header)
+		if out.Code != test.code {
+			t.Errorf("%s %v: unexpected status code %d", test.method, test.header, out.Code)
+		}
+		if test.code == 200 && out.Body.String() != "article "+test.method {
+			t.Errorf("%s %v: unexpected body %s", test.method, test.header, out.Body.String())
+		}
+		if test.code == 304 && out.Body.Len() != 0 {
+			t.Errorf("%s %v: body sent with 304", test.method, test.header)
+		}
+	}
+
+	out := doSimpleRequest("GET", "/conditional/article/", nil)
+	if out.Header().Get("ETag") != `"article-1"` || out.Header().Get("Last-Modified") != modified {
+		t.Errorf("Unexpected headers: %v", out.Header())
+	}
+}
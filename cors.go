@@ -0,0 +1,277 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+CORSOptions holds a policy for cross-origin resource sharing, which lets
+scripts on other sites make requests to an App.
+
+AllowOrigins lists the origins, such as "https://example.com", that requests
+are allowed from. An origin can contain a "*" wildcard, as in
+"https://*.example.com", and "*" on its own allows every origin.
+AllowOriginPatterns are regular expressions that allowed origins must match
+in full.
+
+AllowMethods lists the methods allowed by preflight requests. It defaults to
+the methods the matched route has targets for. AllowHeaders lists the request
+headers allowed by preflight requests, and defaults to allowing the headers
+asked for. ExposeHeaders lists the response headers that scripts can read.
+
+AllowCredentials lets requests include cookies and other credentials. It
+can't be used with "*" in AllowOrigins, as that would let any site make
+requests with the user's credentials. MaxAge is how long the result of a
+preflight request can be cached for.
+*/
+type CORSOptions struct {
+	AllowOrigins        []string
+	AllowOriginPatterns []string
+	AllowMethods        []string
+	AllowHeaders        []string
+	ExposeHeaders       []string
+	AllowCredentials    bool
+	MaxAge              time.Duration
+}
+
+// corsPolicy is a compiled CORSOptions.
+type corsPolicy struct {
+	options   CORSOptions
+	anyOrigin bool
+	origins   []*regexp.Regexp
+}
+
+func newCORSPolicy(options *CORSOptions) (*corsPolicy, error) {
+	if options == nil {
+		return nil, nil
+	}
+	p := &corsPolicy{options: *options}
+	for _, origin := range options.AllowOrigins {
+		if origin == "*" {
+			if options.AllowCredentials {
+				return nil, errors.New(`CORS policy can't allow credentials from any origin "*"`)
+			}
+			p.anyOrigin = true
+			continue
+		}
+		pattern := strings.Replace(regexp.QuoteMeta(strings.ToLower(origin)), `\*`, `[^/]+`, -1)
+		p.origins = append(p.origins, regexp.MustCompile("^"+pattern+"$"))
+	}
+	for _, pattern := range options.AllowOriginPatterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, err
+		}
+		p.origins = append(p.origins, re)
+	}
+	return p, nil
+}
+
+/*
+SetCORS sets the CORS policy for all of the App's routes, including those of
+the Apps mounted in it. A policy set in the RouteOptions of a route, or of
+the route an App is mounted at, applies instead of it.
+
+Preflight requests are answered automatically for routes with a policy,
+unless the route has an OPTIONS target.
+
+	app.SetCORS(&uweb.CORSOptions{
+		AllowOrigins:  []string{"https://*.example.com"},
+		ExposeHeaders: []string{"X-Total-Count"},
+		MaxAge:        time.Hour,
+	})
+*/
+func (a *App) SetCORS(options *CORSOptions) error {
+	p, err := newCORSPolicy(options)
+	if err != nil {
+		return err
+	}
+	a.cors = p
+	return nil
+}
+
+func (p *corsPolicy) allowOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	for _, re := range p.origins {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// setOrigin sets the headers that allow origin to read the response,
+// returning false if the origin isn't allowed.
+func (p *corsPolicy) setOrigin(h http.Header, origin string) bool {
+	if !p.anyOrigin {
+		addVary(h, "Origin")
+	}
+	if origin == "" || !p.allowOrigin(origin) {
+		return false
+	}
+	if p.anyOrigin {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.options.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	return true
+}
+
+// apply sets the CORS headers for the response to an actual, not preflight,
+// request.
+func (p *corsPolicy) apply(ctx *Context, resp *Response) {
+	h := resp.Header()
+	if h.Get("Access-Control-Allow-Origin") != "" {
+		return
+	}
+	if p.setOrigin(h, ctx.Request.Header.Get("Origin")) && len(p.options.ExposeHeaders) > 0 {
+		h.Set("Access-Control-Expose-Headers", strings.Join(p.options.ExposeHeaders, ", "))
+	}
+}
+
+// setCORS sets the policy for the request, which can be replaced by a more
+// specific one until the response is sent.
+func (c *Context) setCORS(p *corsPolicy) {
+	if c.cors == nil {
+		c.OnBeforeWrite(func() {
+			if c.cors != nil {
+				c.cors.apply(c, c.Response)
+			}
+		})
+	}
+	c.cors = p
+}
+
+// preflight answers a CORS preflight request for a route with a CORS
+// policy. It returns nil if the request should be dispatched as usual, such
+// as when the route has an OPTIONS target or is a mounted App, which answers
+// the request itself.
+func (a *App) preflight(ctx *Context) *Response {
+	r := ctx.Request
+	method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	origin := r.Header.Get("Origin")
+	if ctx.Method != "OPTIONS" || method == "" || origin == "" {
+		return nil
+	}
+
+	var matched *route
+	var methods []string
+	found := false
+	for _, route := range a.router.routes {
+		if args, _ := route.Parse(ctx.Path); args == nil {
+			continue
+		}
+		found = true
+		if _, ok := route.targets["OPTIONS"]; ok {
+			return nil
+		}
+		if matched == nil && route.TargetForMethod(method) != nil {
+			if route.mount {
+				return nil
+			}
+			matched = route
+		}
+		methods = append(methods, route.allowedMethods()...)
+	}
+	if !found {
+		return nil
+	}
+
+	policy := ctx.cors
+	if matched != nil && matched.cors != nil {
+		policy = matched.cors
+	}
+	if policy == nil {
+		return nil
+	}
+	// the headers for actual requests don't apply
+	ctx.cors = nil
+
+	resp := NewResponse()
+	resp.Code = 204
+	h := resp.Header()
+	addVary(h, "Access-Control-Request-Method")
+	addVary(h, "Access-Control-Request-Headers")
+
+	if len(policy.options.AllowMethods) > 0 {
+		methods = policy.options.AllowMethods
+	} else if matched != nil && !containsFold(methods, method) {
+		// an ANY target
+		methods = append(methods, method)
+	}
+	allowed := matched != nil && containsFold(methods, method)
+
+	var headers []string
+	for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if header = strings.TrimSpace(header); header != "" {
+			headers = append(headers, header)
+			if len(policy.options.AllowHeaders) > 0 && !containsFold(policy.options.AllowHeaders, header) {
+				allowed = false
+			}
+		}
+	}
+	if len(policy.options.AllowHeaders) > 0 {
+		headers = policy.options.AllowHeaders
+	}
+
+	if !allowed || !policy.setOrigin(h, origin) {
+		// without the headers the browser blocks the request
+		return resp
+	}
+	h.Set("Access-Control-Allow-Methods", strings.Join(uniqueMethods(methods), ", "))
+	if len(headers) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if policy.options.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(policy.options.MaxAge/time.Second)))
+	}
+	return resp
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// uniqueMethods upper cases methods and removes duplicates, keeping them in
+// order.
+func uniqueMethods(methods []string) []string {
+	var unique []string
+	for _, method := range methods {
+		if method = strings.ToUpper(method); !containsFold(unique, method) {
+			unique = append(unique, method)
+		}
+	}
+	return unique
+}
+
+// addVary adds a header to the Vary header, unless it is already listed.
+func addVary(h http.Header, header string) {
+	for _, value := range h["Vary"] {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), header) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", header)
+}
@@ -0,0 +1,180 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb_test
+
+import (
+	"github.com/calebbrown/uweb"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func init() {
+	corsApp := uweb.NewApp()
+	app.Mount("^cors/", corsApp)
+	corsApp.SetCORS(&uweb.CORSOptions{
+		AllowOrigins:        []string{"https://example.com", "https://*.example.org"},
+		AllowOriginPatterns: []string{`http://localhost:[0-9]+`},
+		ExposeHeaders:       []string{"X-Total-Count"},
+		MaxAge:              time.Hour,
+	})
+	corsApp.Get("^items/$", func() string { return "items" })
+	corsApp.Post("^items/$", func() string { return "created" })
+	corsApp.Get("^missing/$", func() { uweb.Abort(404, "Not Found") })
+	corsApp.RouteWithOptions("^public/$", "PUT", func() string { return "public" }, &uweb.RouteOptions{
+		CORS: &uweb.CORSOptions{
+			AllowOrigins: []string{"*"},
+			AllowHeaders: []string{"Content-Type"},
+		},
+	})
+	corsApp.RouteWithOptions("^account/$", "GET", func() string { return "account" }, &uweb.RouteOptions{
+		CORS: &uweb.CORSOptions{
+			AllowOrigins:     []string{"https://*.example.com"},
+			AllowCredentials: true,
+		},
+	})
+	corsApp.Options("^custom/$", func() string { return "custom" })
+	corsApp.Get("^any/$", func() string { return "any" })
+	corsApp.Route("^any/$", func() string { return "any" })
+	corsApp.Route("^anyonly/$", func() string { return "any" })
+
+	api := uweb.NewApp()
+	api.Get("^users/$", func() string { return "users" })
+	api.Delete("^users/$", func() string { return "deleted" })
+	corsApp.MountWithOptions("^api/", api, &uweb.RouteOptions{
+		CORS: &uweb.CORSOptions{AllowOrigins: []string{"https://api.example.com"}},
+	})
+}
+
+func preflight(a *uweb.App, url, origin, method, headers string) *httptest.ResponseRecorder {
+	h := map[string]string{"Origin": origin, "Access-Control-Request-Method": method}
+	if headers != "" {
+		h["Access-Control-Request-Headers"] = headers
+	}
+	return serve(a, "OPTIONS", url, nil, h)
+}
+
+func TestCORSRequests(t *testing.T) {
+	tests := []struct {
+		method, url, origin string
+		code                int
+		allowOrigin, expose string
+	}{
+		{"GET", "/cors/items/", "https://example.com", 200, "https://example.com", "X-Total-Count"},
+		{"POST", "/cors/items/", "https://a.b.example.org", 200, "https://a.b.example.org", "X-Total-Count"},
+		{"GET", "/cors/items/", "http://localhost:8080", 200, "http://localhost:8080", "X-Total-Count"},
+		{"GET", "/cors/items/", "https://evil.example.net", 200, "", ""},
+		{"GET", "/cors/items/", "https://example.org", 200, "", ""},
+		{"GET", "/cors/items/", "", 200, "", ""},
+		{"GET", "/cors/missing/", "https://example.com", 404, "https://example.com", "X-Total-Count"},
+		{"GET", "/cors/nothing/", "https://example.com", 404, "https://example.com", "X-Total-Count"},
+		{"GET", "/cors/api/users/", "https://api.example.com", 200, "https://api.example.com", ""},
+		{"GET", "/cors/api/users/", "https://example.com", 200, "", ""},
+	}
+	for _, test := range tests {
+		out := serve(app, test.method, test.url, nil, map[string]string{"Origin": test.origin})
+		h := out.Header()
+		if out.Code != test.code {
+			t.Errorf("%s %s %s: unexpected status code %d", test.method, test.url, test.origin, out.Code)
+		}
+		if v := h.Get("Access-Control-Allow-Origin"); v != test.allowOrigin {
+			t.Errorf("%s %s %s: unexpected allowed origin %q", test.method, test.url, test.origin, v)
+		}
+		if v := h.Get("Access-Control-Expose-Headers"); v != test.expose {
+			t.Errorf("%s %s %s: unexpected exposed headers %q", test.method, test.url, test.origin, v)
+		}
+		if v := h.Get("Vary"); v != "Origin" {
+			t.Errorf("%s %s %s: unexpected vary %q", test.method, test.url, test.origin, v)
+		}
+	}
+
+	out := serve(app, "PUT", "/cors/public/", nil, map[string]string{"Origin": "https://anywhere.example.net"})
+	if out.Header().Get("Access-Control-Allow-Origin") != "*" || out.Header().Get("Vary") != "" {
+		t.Errorf("Unexpected headers: %v", out.Header())
+	}
+
+	// credentials need the origin to be sent back
+	out = serve(app, "GET", "/cors/account/", nil, map[string]string{"Origin": "https://app.example.com"})
+	if out.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		out.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("Unexpected headers: %v", out.Header())
+	}
+	out = serve(app, "GET", "/cors/account/", nil, map[string]string{"Origin": "https://evil.example.net"})
+	if out.Header().Get("Access-Control-Allow-Origin") != "" || out.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Errorf("Unexpected headers: %v", out.Header())
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	tests := []struct {
+		url, origin, method, headers string
+		allowOrigin, methods         string
+		allowHeaders                 string
+	}{
+		{"/cors/items/", "https://example.com", "POST", "Content-Type, X-Token",
+			"https://example.com", "GET, HEAD, POST", "Content-Type, X-Token"},
+		{"/cors/items/", "https://example.com", "DELETE", "", "", "", ""},
+		{"/cors/items/", "https://evil.example.net", "POST", "", "", "", ""},
+		{"/cors/public/", "https://evil.example.net", "PUT", "content-type",
+			"*", "PUT", "Content-Type"},
+		{"/cors/public/", "https://evil.example.net", "PUT", "X-Token", "", "", ""},
+		{"/cors/any/", "https://example.com", "PATCH", "", "https://example.com", "GET, HEAD, PATCH", ""},
+		{"/cors/anyonly/", "https://example.com", "PUT", "", "https://example.com", "PUT", ""},
+		{"/cors/api/users/", "https://api.example.com", "DELETE", "",
+			"https://api.example.com", "DELETE, GET, HEAD", ""},
+		{"/cors/api/users/", "https://example.com", "DELETE", "", "", "", ""},
+	}
+	for _, test := range tests {
+		out := preflight(app, test.url, test.origin, test.method, test.headers)
+		h := out.Header()
+		if out.Code != 204 || out.Body.Len() != 0 {
+			t.Errorf("%s %s: unexpected response %d %s", test.url, test.method, out.Code, out.Body.String())
+		}
+		if v := h.Get("Access-Control-Allow-Origin"); v != test.allowOrigin {
+			t.Errorf("%s %s: unexpected allowed origin %q", test.url, test.method, v)
+		}
+		if v := h.Get("Access-Control-Allow-Methods"); v != test.methods {
+			t.Errorf("%s %s: unexpected allowed methods %q", test.url, test.method, v)
+		}
+		if v := h.Get("Access-Control-Allow-Headers"); v != test.allowHeaders {
+			t.Errorf("%s %s: unexpected allowed headers %q", test.url, test.method, v)
+		}
+		if v := h.Get("Access-Control-Expose-Headers"); v != "" {
+			t.Errorf("%s %s: exposed headers sent for preflight %q", test.url, test.method, v)
+		}
+	}
+
+	out := preflight(app, "/cors/items/", "https://example.com", "GET", "")
+	if v := out.Header().Get("Access-Control-Max-Age"); v != "3600" {
+		t.Errorf("Unexpected max age %q", v)
+	}
+
+	// OPTIONS targets answer preflight requests themselves
+	out = preflight(app, "/cors/custom/", "https://example.com", "GET", "")
+	if out.Body.String() != "custom" {
+		t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+	}
+}
+
+func TestCORSWithoutPolicy(t *testing.T) {
+	a := uweb.NewApp()
+	a.Get("^items/$", func() string { return "items" })
+
+	out := preflight(a, "/items/", "https://example.com", "GET", "")
+	if out.Code != 204 || out.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Unexpected preflight response: %d %v", out.Code, out.Header())
+	}
+	out = serve(a, "GET", "/items/", nil, map[string]string{"Origin": "https://example.com"})
+	if out.Header().Get("Access-Control-Allow-Origin") != "" || out.Header().Get("Vary") != "" {
+		t.Errorf("Unexpected CORS headers: %v", out.Header())
+	}
+
+	if err := a.SetCORS(&uweb.CORSOptions{AllowOriginPatterns: []string{"("}}); err == nil {
+		t.Error("Invalid origin pattern accepted")
+	}
+	if err := a.SetCORS(&uweb.CORSOptions{AllowOrigins: []string{"*"}, AllowCredentials: true}); err == nil {
+		t.Error("Credentials allowed from any origin")
+	}
+}
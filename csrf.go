@@ -0,0 +1,225 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+)
+
+const csrfTokenSize = 32
+
+// CSRFOptions holds the settings for CSRF protection.
+//
+// CookieName is the name of the cookie holding the token. It defaults to
+// "csrf_token". CookieOptions are used for the cookie, and default to
+// Config.CookieOptions.
+//
+// The token is read from the HeaderName header, which defaults to
+// "X-CSRF-Token", or from the FieldName field of a form, which defaults to
+// "csrf_token".
+//
+// TrustedOrigins lists the hosts, other than the one the request was made
+// to, that requests may come from, such as "app.example.com".
+type CSRFOptions struct {
+	CookieName     string
+	CookieOptions  *CookieOptions
+	HeaderName     string
+	FieldName      string
+	TrustedOrigins []string
+}
+
+// CSRFProtection is a Plugin that protects an App's routes from cross-site
+// request forgery. See CSRF.
+type CSRFProtection struct {
+	options CSRFOptions
+}
+
+/*
+CSRF creates a Plugin that protects the routes of an App from cross-site
+request forgery, using a token kept in a cookie that must also be sent with
+each unsafe request (POST, PUT, PATCH, DELETE and any others that aren't
+GET, HEAD, OPTIONS or TRACE).
+
+Forms include the token in a hidden field, using Context.CSRFField or the
+csrfField func in templates, and scripts send it in the X-CSRF-Token header
+using Context.CSRFToken. The Origin or Referer header of an unsafe request
+must also match the host the request was made to, or one of the
+TrustedOrigins. Requests that fail either check are aborted with a 403
+ErrorResponse.
+
+Routes that are called by other sites, such as webhooks, can opt out by
+skipping the plugin:
+
+	csrf := uweb.CSRF(nil)
+	app.Install(csrf)
+	app.RouteWithOptions("^hooks/$", "POST", hook, &uweb.RouteOptions{
+		Skip: []uweb.Plugin{csrf},
+	})
+*/
+func CSRF(options *CSRFOptions) *CSRFProtection {
+	p := &CSRFProtection{}
+	if options != nil {
+		p.options = *options
+	}
+	if p.options.CookieName == "" {
+		p.options.CookieName = "csrf_token"
+	}
+	if p.options.HeaderName == "" {
+		p.options.HeaderName = "X-CSRF-Token"
+	}
+	if p.options.FieldName == "" {
+		p.options.FieldName = "csrf_token"
+	}
+	return p
+}
+
+// csrfState holds the token for a request.
+type csrfState struct {
+	protection *CSRFProtection
+	ctx        *Context
+	token      []byte
+	isNew      bool
+}
+
+// Apply implements Plugin.
+func (p *CSRFProtection) Apply(next Handler, route *RouteInfo) Handler {
+	return HandlerFunc(func(ctx *Context) *Response {
+		state := &csrfState{protection: p, ctx: ctx}
+		if value, err := ctx.GetCookie(p.options.CookieName); err == nil {
+			if token, err := base64.RawURLEncoding.DecodeString(value); err == nil && len(token) == csrfTokenSize {
+				state.token = token
+			}
+		}
+		switch ctx.Request.Method {
+		case "GET", "HEAD", "OPTIONS", "TRACE":
+		default:
+			p.check(state)
+		}
+
+		ctx.csrf = state
+		ctx.OnBeforeWrite(state.setCookie)
+		resp := next.Handle(ctx)
+		state.setCookie()
+		keepCookie(ctx, resp, p.options.CookieName)
+		return resp
+	})
+}
+
+// check aborts an unsafe request that didn't come from a trusted origin, or
+// didn't send the token from the cookie.
+func (p *CSRFProtection) check(state *csrfState) {
+	r := state.ctx.Request
+	if !p.trustedOrigin(r) {
+		panic(NewError(403, "CSRF check failed: the request's origin isn't trusted"))
+	}
+	if state.token == nil {
+		panic(NewError(403, "CSRF check failed: the CSRF cookie isn't set"))
+	}
+	sent := r.Header.Get(p.options.HeaderName)
+	if sent == "" {
+		sent = state.ctx.Form().String(p.options.FieldName, "")
+	}
+	if !validCSRFToken(sent, state.token) {
+		panic(NewError(403, "CSRF check failed: the CSRF token is missing or incorrect"))
+	}
+}
+
+// trustedOrigin checks the Origin header of a request, or the Referer if
+// there is no Origin. Requests without either are only trusted if they
+// weren't made over TLS, as some clients don't send them.
+func (p *CSRFProtection) trustedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+	if origin == "" {
+		return r.TLS == nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if u.Host == r.Host {
+		return true
+	}
+	for _, host := range p.options.TrustedOrigins {
+		if u.Host == host {
+			return true
+		}
+	}
+	return false
+}
+
+// mask returns the token XORed with a random pad, which is included, so the
+// value changes on each request. This stops the token being guessed from
+// compressed responses (BREACH).
+func (s *csrfState) mask() string {
+	if s.token == nil {
+		s.token = make([]byte, csrfTokenSize)
+		if _, err := rand.Read(s.token); err != nil {
+			panic(err)
+		}
+		s.isNew = true
+	}
+	masked := make([]byte, 2*csrfTokenSize)
+	if _, err := rand.Read(masked[:csrfTokenSize]); err != nil {
+		panic(err)
+	}
+	for i, b := range s.token {
+		masked[csrfTokenSize+i] = masked[i] ^ b
+	}
+	return base64.RawURLEncoding.EncodeToString(masked)
+}
+
+func validCSRFToken(sent string, token []byte) bool {
+	masked, err := base64.RawURLEncoding.DecodeString(sent)
+	if err != nil || len(masked) != 2*csrfTokenSize {
+		return false
+	}
+	unmasked := make([]byte, csrfTokenSize)
+	for i := range unmasked {
+		unmasked[i] = masked[i] ^ masked[csrfTokenSize+i]
+	}
+	return subtle.ConstantTimeCompare(unmasked, token) == 1
+}
+
+// setCookie sets the cookie for a token created during the request.
+func (s *csrfState) setCookie() {
+	if !s.isNew {
+		return
+	}
+	s.isNew = false
+	options := s.protection.options.CookieOptions
+	s.ctx.Response.SetCookieWithOptions(s.protection.options.CookieName,
+		base64.RawURLEncoding.EncodeToString(s.token), options)
+}
+
+// CSRFToken returns the token that must be sent with unsafe requests, in the
+// X-CSRF-Token header or a form field. A different value is returned each
+// time, but they are all valid until the cookie changes. It panics if the
+// CSRF plugin isn't applied to the route.
+func (c *Context) CSRFToken() string {
+	if c.csrf == nil {
+		panic("CSRF protection not in use")
+	}
+	return c.csrf.mask()
+}
+
+// CSRFField returns a hidden form field holding the CSRF token.
+//
+//	<form method="POST">{{csrfField}}...</form>
+func (c *Context) CSRFField() template.HTML {
+	if c.csrf == nil {
+		panic("CSRF protection not in use")
+	}
+	return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`,
+		template.HTMLEscapeString(c.csrf.protection.options.FieldName), c.csrf.mask()))
+}
@@ -0,0 +1,179 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb_test
+
+import (
+	"crypto/tls"
+	"github.com/calebbrown/uweb"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func init() {
+	csrfApp := uweb.NewApp()
+	app.Mount("^csrf/", csrfApp)
+	csrf := uweb.CSRF(&uweb.CSRFOptions{TrustedOrigins: []string{"app.example.com"}})
+	csrfApp.Install(csrf)
+	csrfApp.LoadTemplates(fstest.MapFS{
+		"form.html": {Data: []byte(`<form method="POST">{{csrfField}}</form>`)},
+	})
+	csrfApp.Get("^form/$", func() uweb.TemplateResult {
+		return uweb.Template("form.html", nil)
+	})
+	csrfApp.Get("^token/$", func(ctx *uweb.Context) string {
+		return ctx.CSRFToken()
+	})
+	csrfApp.Get("^plain/$", func() string {
+		return "plain"
+	})
+	csrfApp.Post("^save/$", func() string {
+		return "saved"
+	})
+	csrfApp.Delete("^save/$", func() string {
+		return "deleted"
+	})
+	csrfApp.RouteWithOptions("^hook/$", "POST", func() string {
+		return "hooked"
+	}, &uweb.RouteOptions{Skip: []uweb.Plugin{csrf}})
+	csrfApp.Error(403, func(e *uweb.ErrorResponse) string {
+		return "forbidden: " + e.Message
+	})
+}
+
+// csrfToken gets a token and the cookie it is valid with.
+func csrfToken(t *testing.T) (string, *http.Cookie) {
+	out := doSimpleRequest("GET", "http://example.com/csrf/token/", nil)
+	for _, c := range out.Result().Cookies() {
+		if c.Name == "csrf_token" {
+			return out.Body.String(), c
+		}
+	}
+	t.Fatal("CSRF cookie not set")
+	return "", nil
+}
+
+func csrfPost(method, path string, form url.Values, cookie *http.Cookie, header map[string]string) *httptest.ResponseRecorder {
+	h := map[string]string{"Content-Type": "application/x-www-form-urlencoded"}
+	for k, v := range cookieHeader(cookie) {
+		h[k] = v
+	}
+	for k, v := range header {
+		h[k] = v
+	}
+	return serve(app, method, "http://example.com/csrf"+path, strings.NewReader(form.Encode()), h)
+}
+
+func TestCSRF(t *testing.T) {
+	token, cookie := csrfToken(t)
+	other, _ := csrfToken(t)
+	if token == other {
+		t.Error("Token not masked")
+	}
+
+	tests := []struct {
+		method string
+		form   url.Values
+		cookie *http.Cookie
+		header map[string]string
+		body   string
+	}{
+		{"POST", url.Values{"csrf_token": {token}}, cookie, nil, "saved"},
+		{"DELETE", nil, cookie, map[string]string{"X-CSRF-Token": token}, "deleted"},
+		{"POST", url.Values{"csrf_token": {token}}, cookie,
+			map[string]string{"Origin": "http://example.com"}, "saved"},
+		{"POST", url.Values{"csrf_token": {token}}, cookie,
+			map[string]string{"Referer": "https://app.example.com/page/"}, "saved"},
+		{"POST", nil, nil, nil, "forbidden: CSRF check failed: the CSRF cookie isn't set"},
+		{"POST", nil, cookie, nil, "forbidden: CSRF check failed: the CSRF token is missing or incorrect"},
+		{"POST", url.Values{"csrf_token": {"wrong"}}, cookie, nil,
+			"forbidden: CSRF check failed: the CSRF token is missing or incorrect"},
+		{"POST", url.Values{"csrf_token": {token}}, cookie,
+			map[string]string{"Origin": "http://evil.example.net"},
+			"forbidden: CSRF check failed: the request's origin isn't trusted"},
+		{"POST", url.Values{"csrf_token": {token}}, cookie,
+			map[string]string{"Referer": "http://evil.example.net/"},
+			"forbidden: CSRF check failed: the request's origin isn't trusted"},
+	}
+	for i, test := range tests {
+		out := csrfPost(test.method, "/save/", test.form, test.cookie, test.header)
+		if out.Body.String() != test.body {
+			t.Errorf("%d: unexpected response %d %s", i, out.Code, out.Body.String())
+		}
+		if test.body != "saved" && test.body != "deleted" && out.Code != 403 {
+			t.Errorf("%d: unexpected status code %d", i, out.Code)
+		}
+	}
+
+	// a token from another cookie is rejected
+	_, otherCookie := csrfToken(t)
+	if out := csrfPost("POST", "/save/", url.Values{"csrf_token": {token}}, otherCookie, nil); out.Code != 403 {
+		t.Errorf("Token accepted with another cookie: %d", out.Code)
+	}
+
+	// routes can opt out
+	if out := csrfPost("POST", "/hook/", nil, nil, nil); out.Body.String() != "hooked" {
+		t.Errorf("Skipped route rejected: %d %s", out.Code, out.Body.String())
+	}
+}
+
+func TestCSRFOverTLS(t *testing.T) {
+	token, cookie := csrfToken(t)
+	req, _ := http.NewRequest("POST", "https://example.com/csrf/save/", strings.NewReader("csrf_token="+token))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookie)
+	req.TLS = &tls.ConnectionState{}
+	out := httptest.NewRecorder()
+	app.ServeHTTP(out, req)
+	if out.Code != 403 {
+		t.Errorf("Request without Referer accepted over TLS: %d", out.Code)
+	}
+
+	req, _ = http.NewRequest("POST", "https://example.com/csrf/save/", strings.NewReader("csrf_token="+token))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Referer", "https://example.com/csrf/form/")
+	req.AddCookie(cookie)
+	req.TLS = &tls.ConnectionState{}
+	out = httptest.NewRecorder()
+	app.ServeHTTP(out, req)
+	if out.Body.String() != "saved" {
+		t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+	}
+}
+
+func TestCSRFCookie(t *testing.T) {
+	// the cookie is only set when a token is used
+	if out := doSimpleRequest("GET", "/csrf/plain/", nil); out.Header().Get("Set-Cookie") != "" {
+		t.Errorf("Unexpected cookie: %s", out.Header().Get("Set-Cookie"))
+	}
+	_, cookie := csrfToken(t)
+	if out := serve(app, "GET", "/csrf/token/", nil, cookieHeader(cookie)); out.Header().Get("Set-Cookie") != "" {
+		t.Errorf("Cookie set again: %s", out.Header().Get("Set-Cookie"))
+	}
+
+	// templates embed the token in a form field
+	out := serve(app, "GET", "/csrf/form/", nil, cookieHeader(cookie))
+	match := regexp.MustCompile(`<input type="hidden" name="csrf_token" value="([^"]+)">`).FindStringSubmatch(out.Body.String())
+	if match == nil {
+		t.Fatalf("No token in form: %s", out.Body.String())
+	}
+	if out := csrfPost("POST", "/save/", url.Values{"csrf_token": {match[1]}}, cookie, nil); out.Body.String() != "saved" {
+		t.Errorf("Form token rejected: %d %s", out.Code, out.Body.String())
+	}
+}
+
+func TestCSRFWithoutPlugin(t *testing.T) {
+	a := uweb.NewApp()
+	a.Get("^$", func(ctx *uweb.Context) string {
+		return ctx.CSRFToken()
+	})
+	if out := serve(a, "GET", "/", nil, nil); out.Code != 500 {
+		t.Errorf("Unexpected response: %d", out.Code)
+	}
+}
@@ -5,6 +5,7 @@
 package uweb_test
 
 import (
+	"fmt"
 	"github.com/calebbrown/uweb"
 )
 
@@ -34,3 +35,17 @@ func ExampleMount() {
 
 	uweb.Run("localhost:6060")
 }
+
+// This example demonstrates building the path to a named route.
+func ExampleApp_URL() {
+	app := uweb.NewApp()
+
+	app.RouteWithOptions("^blog/([0-9]+)/([a-z-]+)/$", "GET",
+		func(id, slug string) string {
+			return "Post " + id
+		}, &uweb.RouteOptions{Name: "blog-post"})
+
+	path, _ := app.URL("blog-post", 42, "hello-world")
+	fmt.Println(path)
+	// Output: /blog/42/hello-world/
+}
@@ -10,27 +10,20 @@ submissions.
 package main
 
 import (
-	"bytes"
+	"embed"
 	"encoding/json"
 	"github.com/calebbrown/uweb"
-	"html/template"
+	"io/fs"
 	"os"
 )
 
 // Where to store the message data
 const MessageFile = "messages.json"
 
-// The template to use to render the index page
-const IndexTemplate = `<html>
-<body>
-<form action="/save/" method="POST">
-<input type="text" name="message" autofocus>
-<button>New Message</button>
-</form>
-{{range $index, $message := .Messages }}<div>{{ $message }}</div>
-{{end}}
-</body>
-</html>`
+// The templates used to render the pages
+//
+//go:embed templates
+var templates embed.FS
 
 func loadMessages() []string {
 	// Open the file for reading
@@ -66,12 +59,7 @@ func saveMessage(message string) {
 	enc.Encode(messages)
 }
 
-func index() *bytes.Buffer {
-	t, err := template.New("index").Parse(IndexTemplate)
-	if err != nil {
-		panic(err)
-	}
-
+func index() uweb.TemplateResult {
 	messages := loadMessages()
 
 	// reverse the messages so they appear in descending order
@@ -80,25 +68,25 @@ func index() *bytes.Buffer {
 	}
 
 	// render the template
-	var b bytes.Buffer
-	err = t.Execute(&b, struct{ Messages []string }{Messages: messages})
-	if err != nil {
-		panic(err)
-	}
-
-	return &b
+	return uweb.Template("index.html", struct{ Messages []string }{Messages: messages})
 }
 
 func save(ctx *uweb.Context) {
-	message := ctx.Request.FormValue("message")
+	message := ctx.Form().String("message", "")
 	saveMessage(message)
 	uweb.Redirect("/")
 }
 
 func main() {
 	uweb.Config.Debug = true
+	root, _ := fs.Sub(templates, "templates")
+	if err := uweb.LoadTemplates(root); err != nil {
+		panic(err)
+	}
+	// reject forms posted from other sites
+	uweb.Install(uweb.CSRF(nil))
 	uweb.Get("^$", index)
-	uweb.Post("^save/$", save)
+	uweb.RouteWithOptions("^save/$", "POST", save, &uweb.RouteOptions{Name: "save"})
 	if err := uweb.Run("localhost:6062"); err != nil {
 		panic(err)
 	}
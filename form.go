@@ -0,0 +1,164 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb
+
+import (
+	"errors"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Values holds the values of a query string or form, with accessors that
+// convert them and fall back to a default when a value is missing or
+// invalid.
+//
+//	page := ctx.Query().Int("page", 1)
+//	tags := ctx.Form().Strings("tag", nil)
+type Values url.Values
+
+// Has reports whether key is set, even if it's empty.
+func (v Values) Has(key string) bool {
+	_, ok := v[key]
+	return ok
+}
+
+// String returns the first value of key, or def if it isn't set.
+func (v Values) String(key, def string) string {
+	if values := v[key]; len(values) > 0 {
+		return values[0]
+	}
+	return def
+}
+
+// Int returns the first value of key as an int, or def if it isn't set or
+// isn't an int.
+func (v Values) Int(key string, def int) int {
+	if i, err := strconv.Atoi(strings.TrimSpace(v.String(key, ""))); err == nil {
+		return i
+	}
+	return def
+}
+
+// Bool returns the first value of key as a bool, or def if it isn't set or
+// isn't a bool. "on" and "off", as sent by checkboxes, are understood along
+// with the values accepted by strconv.ParseBool.
+func (v Values) Bool(key string, def bool) bool {
+	switch s := strings.ToLower(strings.TrimSpace(v.String(key, ""))); s {
+	case "on":
+		return true
+	case "off":
+		return false
+	default:
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b
+		}
+	}
+	return def
+}
+
+// Strings returns all the values of key, or def if it isn't set.
+func (v Values) Strings(key string, def []string) []string {
+	if values := v[key]; len(values) > 0 {
+		return values
+	}
+	return def
+}
+
+// Ints returns all the values of key as ints, or def if it isn't set or any
+// of the values isn't an int.
+func (v Values) Ints(key string, def []int) []int {
+	values := v[key]
+	if len(values) == 0 {
+		return def
+	}
+	ints := make([]int, len(values))
+	for i, s := range values {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return def
+		}
+		ints[i] = n
+	}
+	return ints
+}
+
+// Query returns the values in the request's query string.
+func (c *Context) Query() Values {
+	return Values(c.Get)
+}
+
+// Form returns the values sent in the body of a url-encoded or multipart
+// form. Values in the query string are not included, use Query for those.
+//
+// A request with a malformed body is aborted with a 400 error, or a 413
+// error if it is larger than Config.MaxBodySize, or Config.MaxMultipartSize
+// for a multipart form.
+func (c *Context) Form() Values {
+	c.parseForm()
+	return Values(c.Request.PostForm)
+}
+
+// File returns the first file uploaded with the name key in a multipart
+// form, or nil if there isn't one.
+func (c *Context) File(key string) *multipart.FileHeader {
+	if files := c.Files(key); len(files) > 0 {
+		return files[0]
+	}
+	return nil
+}
+
+// Files returns the files uploaded with the name key in a multipart form.
+//
+// Up to Config.MultipartMemory bytes of a form are kept in memory, the rest
+// are stored in temporary files which are removed when the request ends.
+func (c *Context) Files(key string) []*multipart.FileHeader {
+	c.parseForm()
+	if c.Request.MultipartForm == nil {
+		return nil
+	}
+	return c.Request.MultipartForm.File[key]
+}
+
+func (c *Context) parseForm() {
+	if c.Request.PostForm != nil {
+		return
+	}
+	var err error
+	mediaType, _, _ := mime.ParseMediaType(c.Request.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		c.limitBody(Config.MaxMultipartSize)
+		err = c.Request.ParseMultipartForm(Config.MultipartMemory)
+		// only the files of a form parsed here are removed by µweb
+		c.multipartForm = c.Request.MultipartForm
+	} else {
+		c.limitBody(Config.MaxBodySize)
+		err = c.Request.ParseForm()
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			Abort(413, "Request Entity Too Large")
+		}
+		Abort(400, "Bad Request")
+	}
+}
+
+// limitBody limits the request body to n bytes, unless n is zero.
+func (c *Context) limitBody(n int64) {
+	if n > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, n)
+	}
+}
+
+// removeFiles removes the temporary files holding uploaded files.
+func (c *Context) removeFiles() {
+	if c.multipartForm != nil {
+		c.multipartForm.RemoveAll()
+	}
+}
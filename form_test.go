@@ -0,0 +1,201 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb_test
+
+import (
+	"bytes"
+	"github.com/calebbrown/uweb"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQueryValues(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/?name=bob&page=2&bad=x&on=on&yes=true&tag=a&tag=b&n=1&n=2&empty=", nil)
+	q := uweb.NewContext(req).Query()
+
+	if v := q.String("name", "default"); v != "bob" {
+		t.Errorf("String: %s", v)
+	}
+	if v := q.String("missing", "default"); v != "default" {
+		t.Errorf("String default: %s", v)
+	}
+	if v := q.Int("page", 1); v != 2 {
+		t.Errorf("Int: %d", v)
+	}
+	if v := q.Int("bad", 1); v != 1 {
+		t.Errorf("Int invalid: %d", v)
+	}
+	if !q.Bool("on", false) || !q.Bool("yes", false) || !q.Bool("missing", true) || q.Bool("bad", false) {
+		t.Error("Unexpected Bool values")
+	}
+	if v := q.Strings("tag", nil); !reflect.DeepEqual(v, []string{"a", "b"}) {
+		t.Errorf("Strings: %v", v)
+	}
+	if v := q.Ints("n", nil); !reflect.DeepEqual(v, []int{1, 2}) {
+		t.Errorf("Ints: %v", v)
+	}
+	if v := q.Ints("tag", []int{3}); !reflect.DeepEqual(v, []int{3}) {
+		t.Errorf("Ints invalid: %v", v)
+	}
+	if !q.Has("empty") || q.Has("missing") {
+		t.Error("Unexpected Has values")
+	}
+}
+
+func TestFormValues(t *testing.T) {
+	body := strings.NewReader("message=hello&count=3")
+	req, _ := http.NewRequest("POST", "/?message=query", body)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx := uweb.NewContext(req)
+
+	if v := ctx.Form().String("message", ""); v != "hello" {
+		t.Errorf("Form value: %s", v)
+	}
+	if v := ctx.Form().Int("count", 0); v != 3 {
+		t.Errorf("Form int: %d", v)
+	}
+	if v := ctx.Query().String("message", ""); v != "query" {
+		t.Errorf("Query value: %s", v)
+	}
+}
+
+func TestFormErrors(t *testing.T) {
+	a := uweb.NewApp()
+	a.Post("^form/$", func(ctx *uweb.Context) string {
+		return ctx.Form().String("message", "")
+	})
+
+	out := serve(a, "POST", "/form/", strings.NewReader("message=%zz"),
+		map[string]string{"Content-Type": "application/x-www-form-urlencoded"})
+	if out.Code != 400 {
+		t.Errorf("Malformed form: unexpected response %d", out.Code)
+	}
+
+	uweb.Config.MaxBodySize = 10
+	defer func() {
+		uweb.Config.MaxBodySize = 10 << 20
+	}()
+	out = serve(a, "POST", "/form/", strings.NewReader("message="+strings.Repeat("x", 100)),
+		map[string]string{"Content-Type": "application/x-www-form-urlencoded"})
+	if out.Code != 413 {
+		t.Errorf("Large form: unexpected response %d", out.Code)
+	}
+
+	uweb.Config.MaxMultipartSize = 10
+	defer func() {
+		uweb.Config.MaxMultipartSize = 100 << 20
+	}()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("message", strings.Repeat("x", 100))
+	w.Close()
+	out = serve(a, "POST", "/form/", &body, map[string]string{"Content-Type": w.FormDataContentType()})
+	if out.Code != 413 {
+		t.Errorf("Large multipart form: unexpected response %d", out.Code)
+	}
+}
+
+func TestMultipartFiles(t *testing.T) {
+	uweb.Config.MultipartMemory = 10
+	defer func() {
+		uweb.Config.MultipartMemory = 8 << 20
+	}()
+
+	var tempFile string
+	a := uweb.NewApp()
+	a.Post("^upload/$", func(ctx *uweb.Context) string {
+		if ctx.File("missing") != nil {
+			t.Error("Unexpected file")
+		}
+		header := ctx.File("upload")
+		f, err := header.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if osFile, ok := f.(*os.File); ok {
+			tempFile = osFile.Name()
+		}
+		content, _ := ioutil.ReadAll(f)
+		return ctx.Form().String("title", "") + ":" + header.Filename + ":" + string(content)
+	})
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("title", "report")
+	fw, _ := w.CreateFormFile("upload", "report.txt")
+	fw.Write([]byte(strings.Repeat("x", 100)))
+	w.Close()
+
+	out := serve(a, "POST", "/upload/", &body, map[string]string{"Content-Type": w.FormDataContentType()})
+	if out.Body.String() != "report:report.txt:"+strings.Repeat("x", 100) {
+		t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+	}
+	if tempFile == "" {
+		t.Fatal("Upload larger than the memory limit not stored in a file")
+	}
+	if _, err := os.Stat(tempFile); !os.IsNotExist(err) {
+		t.Errorf("Temporary file not removed: %v", err)
+	}
+}
+
+func TestMultipartDefaultLimits(t *testing.T) {
+	a := uweb.NewApp()
+	a.Post("^upload/$", func(ctx *uweb.Context) string {
+		f, err := ctx.File("upload").Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, ok := f.(*os.File); !ok {
+			return "in memory"
+		}
+		return "in a file"
+	})
+
+	// larger than both Config.MultipartMemory and Config.MaxBodySize
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	fw, _ := w.CreateFormFile("upload", "large.bin")
+	fw.Write(bytes.Repeat([]byte("x"), 12<<20))
+	w.Close()
+
+	out := serve(a, "POST", "/upload/", &body, map[string]string{"Content-Type": w.FormDataContentType()})
+	if out.Code != 200 || out.Body.String() != "in a file" {
+		t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+	}
+}
+
+func TestMultipartFilesParsedBefore(t *testing.T) {
+	a := uweb.NewApp()
+	a.Use(uweb.HTTPMiddleware(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.ParseMultipartForm(1 << 20)
+			next.ServeHTTP(w, r)
+		})
+	}))
+	a.Post("^upload/$", func(ctx *uweb.Context) string {
+		if ctx.File("upload") == nil {
+			return "missing"
+		}
+		return ctx.File("upload").Filename
+	})
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	fw, _ := w.CreateFormFile("upload", "report.txt")
+	fw.Write([]byte("report"))
+	w.Close()
+
+	out := serve(a, "POST", "/upload/", &body, map[string]string{"Content-Type": w.FormDataContentType()})
+	if out.Body.String() != "report.txt" {
+		t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb
+
+import (
+	"reflect"
+)
+
+// The HandlerFunc type is an adapter to allow the use of ordinary functions
+// as Handlers.
+type HandlerFunc func(ctx *Context) *Response
+
+// Handle calls f(ctx).
+func (f HandlerFunc) Handle(ctx *Context) *Response {
+	return f(ctx)
+}
+
+/*
+A Middleware wraps a Handler to run code before and after it.
+
+A middleware can return a *Response of its own without calling next to
+short-circuit the request. Errors raised by Abort or Redirect, either in a
+target or in another middleware or plugin, are turned into a *Response before
+they reach the middleware wrapping them.
+
+	func Timer(next uweb.Handler) uweb.Handler {
+		return uweb.HandlerFunc(func(ctx *uweb.Context) *uweb.Response {
+			start := time.Now()
+			ctx.OnBeforeWrite(func() {
+				ctx.Response.Header().Set("X-Time", time.Since(start).String())
+			})
+			resp := next.Handle(ctx)
+			if resp != nil {
+				resp.Header().Set("X-Time", time.Since(start).String())
+			}
+			return resp
+		})
+	}
+
+	app.Use(Timer)
+
+Middleware is run in the following order:
+
+ 1. Middleware added to the App with Use, in the order it was added.
+ 2. Middleware in the RouteOptions of the matched target, or of the route
+    an App is mounted at.
+ 3. Plugins installed on the App with Install, in the order they were
+    installed.
+ 4. The target.
+
+A mounted App runs its own middleware inside the target of the route it is
+mounted at.
+
+Responses written while the target runs, such as those written with
+Context.Stream, have been sent by the time next returns, so middleware adds
+headers to them in a function registered with Context.OnBeforeWrite, as
+Timer does.
+*/
+type Middleware func(next Handler) Handler
+
+/*
+A Plugin is applied to the target of each route in an App, similar to plugins
+in Bottle.
+
+Apply is called for each method of each route, with the route's target
+wrapped in a Handler, and returns the Handler to use instead. Unlike
+Middleware a plugin can inspect the route when it is applied, and return next
+unchanged if it doesn't apply to the route.
+
+A route can opt out of a plugin by listing it in RouteOptions.Skip. Plugins
+are compared with ==, so should be pointers.
+*/
+type Plugin interface {
+	Apply(next Handler, route *RouteInfo) Handler
+}
+
+// RouteInfo describes the route a Plugin is applied to.
+type RouteInfo struct {
+	Pattern string
+	Method  string
+	Options RouteOptions
+}
+
+// chain wraps handler in the middleware, with the first middleware being
+// the outermost. Each middleware is wrapped in a recoverer so the middleware
+// around it sees a response when it aborts.
+func (a *App) chain(middleware []Middleware, handler Handler) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = a.recoverer(middleware[i](handler))
+	}
+	return handler
+}
+
+// skipsPlugin reports whether plugin appears in skip.
+func skipsPlugin(skip []Plugin, plugin Plugin) bool {
+	if !reflect.TypeOf(plugin).Comparable() {
+		return false
+	}
+	for _, s := range skip {
+		if reflect.TypeOf(s) == reflect.TypeOf(plugin) && s == plugin {
+			return true
+		}
+	}
+	return false
+}
+
+// Use adds middleware that wraps every request handled by the App.
+func (a *App) Use(middleware ...Middleware) {
+	a.middleware = append(a.middleware, middleware...)
+}
+
+// Install adds a plugin that is applied to the targets of all the App's
+// routes, including those that have already been added.
+func (a *App) Install(plugin Plugin) {
+	a.plugins = append(a.plugins, plugin)
+	for _, reg := range a.registrations {
+		a.compileTarget(reg)
+	}
+}
+
+// compileTarget wraps the target of a registration in the App's plugins and
+// its route middleware, and sets it as the handler for its route and method.
+func (a *App) compileTarget(reg registration) {
+	route, _ := a.router.GetRoute(reg.pattern)
+	target := reg.target
+	var handler Handler = HandlerFunc(func(ctx *Context) *Response {
+		return a.cast(ctx, target(ctx, ctx.Args...))
+	})
+	handler = a.recoverer(handler)
+
+	info := &RouteInfo{Pattern: reg.pattern, Method: reg.method}
+	if reg.options != nil {
+		info.Options = *reg.options
+	}
+	// the options that apply to every method of the route
+	info.Options.Name = route.name
+	info.Options.Priority = route.priority
+	if route.cors != nil {
+		info.Options.CORS = &route.cors.options
+	}
+	for i := len(a.plugins) - 1; i >= 0; i-- {
+		if !skipsPlugin(info.Options.Skip, a.plugins[i]) {
+			handler = a.recoverer(a.plugins[i].Apply(handler, info))
+		}
+	}
+	handler = a.chain(info.Options.Middleware, handler)
+	if info.Options.API {
+		next := handler
+		handler = HandlerFunc(func(ctx *Context) *Response {
+			ctx.api = true
+			return next.Handle(ctx)
+		})
+	}
+	route.AddTarget(reg.method, handler)
+}
@@ -0,0 +1,250 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb_test
+
+import (
+	"github.com/calebbrown/uweb"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// tracer returns middleware that records its name in the X-Trace header
+// before and after calling the next handler.
+func tracer(name string) uweb.Middleware {
+	return func(next uweb.Handler) uweb.Handler {
+		return uweb.HandlerFunc(func(ctx *uweb.Context) *uweb.Response {
+			ctx.Request.Header.Add("X-Trace", name)
+			resp := next.Handle(ctx)
+			resp.Header().Add("X-Trace", name)
+			return resp
+		})
+	}
+}
+
+func shortCircuit(next uweb.Handler) uweb.Handler {
+	return uweb.HandlerFunc(func(ctx *uweb.Context) *uweb.Response {
+		if ctx.Get.Get("stop") != "" {
+			resp := uweb.NewResponse()
+			resp.Code = 418
+			return resp
+		}
+		return next.Handle(ctx)
+	})
+}
+
+func requireAuth(next uweb.Handler) uweb.Handler {
+	return uweb.HandlerFunc(func(ctx *uweb.Context) *uweb.Response {
+		if ctx.Get.Get("auth") == "" {
+			uweb.Abort(401, "Unauthorized")
+		}
+		return next.Handle(ctx)
+	})
+}
+
+// abortPlugin aborts requests without an "auth" query value.
+type abortPlugin struct{}
+
+func (p *abortPlugin) Apply(next uweb.Handler, route *uweb.RouteInfo) uweb.Handler {
+	return requireAuth(next)
+}
+
+// tracePlugin records the method and name of each route it's applied to.
+type tracePlugin struct {
+	applied []string
+}
+
+func (p *tracePlugin) Apply(next uweb.Handler, route *uweb.RouteInfo) uweb.Handler {
+	p.applied = append(p.applied, route.Method+" "+route.Options.Name)
+	return tracer("plugin")(next)
+}
+
+func traceView(ctx *uweb.Context) string {
+	return strings.Join(ctx.Request.Header["X-Trace"], ",")
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	plugin := &tracePlugin{}
+	a := uweb.NewApp()
+	a.Use(tracer("app1"), tracer("app2"))
+	a.Install(plugin)
+	a.RouteWithOptions("^trace/$", "GET", traceView, &uweb.RouteOptions{
+		Middleware: []uweb.Middleware{tracer("route")},
+	})
+
+	sub := uweb.NewApp()
+	sub.Use(tracer("sub"))
+	sub.Get("^trace/$", traceView)
+	a.MountWithOptions("^sub/", sub, &uweb.RouteOptions{
+		Middleware: []uweb.Middleware{tracer("mount")},
+	})
+
+	out := serve(a, "GET", "/trace/", nil, nil)
+	if out.Body.String() != "app1,app2,route,plugin" {
+		t.Errorf("Unexpected order before target: %s", out.Body.String())
+	}
+	after := strings.Join(out.Header()["X-Trace"], ",")
+	if after != "plugin,route,app2,app1" {
+		t.Errorf("Unexpected order after target: %s", after)
+	}
+
+	out = serve(a, "GET", "/sub/trace/", nil, nil)
+	if out.Body.String() != "app1,app2,mount,plugin,sub" {
+		t.Errorf("Unexpected order in mounted app: %s", out.Body.String())
+	}
+}
+
+func TestMiddlewareErrors(t *testing.T) {
+	a := uweb.NewApp()
+	a.Use(tracer("app"), shortCircuit)
+	a.RouteWithOptions("^private/$", "GET", simpleView1, &uweb.RouteOptions{
+		Middleware: []uweb.Middleware{requireAuth},
+	})
+	a.Get("^redirect/$", redirectView)
+	a.Error(401, func() string { return "custom 401" })
+
+	out := serve(a, "GET", "/private/?stop=1", nil, nil)
+	if out.Code != 418 {
+		t.Errorf("Status code %d != 418", out.Code)
+	}
+
+	out = serve(a, "GET", "/private/", nil, nil)
+	if out.Code != 401 || out.Body.String() != "custom 401" {
+		t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+	}
+	if out.Header().Get("X-Trace") != "app" {
+		t.Error("App middleware didn't see the aborted response")
+	}
+
+	out = serve(a, "GET", "/private/?auth=1", nil, nil)
+	if out.Code != 200 || out.Body.String() != "hello world" {
+		t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+	}
+
+	out = serve(a, "GET", "/redirect/", nil, nil)
+	if out.Code != 302 || out.Header().Get("X-Trace") != "app" {
+		t.Error("App middleware didn't see the redirect")
+	}
+
+	out = serve(a, "GET", "/missing/", nil, nil)
+	if out.Code != 404 || out.Header().Get("X-Trace") != "app" {
+		t.Error("App middleware didn't see the not found response")
+	}
+}
+
+func TestMiddlewareSeesAbort(t *testing.T) {
+	a := uweb.NewApp()
+	a.Use(tracer("outer"), requireAuth)
+	a.Get("^app/$", simpleView1)
+
+	out := serve(a, "GET", "/app/", nil, nil)
+	if out.Code != 401 || out.Header().Get("X-Trace") != "outer" {
+		t.Errorf("Outer middleware didn't see the aborted response: %d", out.Code)
+	}
+
+	b := uweb.NewApp()
+	b.Install(&abortPlugin{})
+	b.RouteWithOptions("^route/$", "GET", simpleView1, &uweb.RouteOptions{
+		Middleware: []uweb.Middleware{tracer("route")},
+	})
+
+	out = serve(b, "GET", "/route/", nil, nil)
+	if out.Code != 401 || out.Header().Get("X-Trace") != "route" {
+		t.Errorf("Route middleware didn't see the plugin's response: %d", out.Code)
+	}
+}
+
+func TestPluginSkip(t *testing.T) {
+	plugin := &tracePlugin{}
+	a := uweb.NewApp()
+	a.RouteWithOptions("^a/$", "GET", traceView, &uweb.RouteOptions{Name: "a"})
+	a.Post("^a/$", traceView)
+	a.RouteWithOptions("^b/$", "GET", traceView, &uweb.RouteOptions{
+		Name: "b",
+		Skip: []uweb.Plugin{plugin},
+	})
+	a.Install(plugin)
+
+	if strings.Join(plugin.applied, ",") != "GET a,POST a" {
+		t.Errorf("Plugin applied to unexpected routes: %v", plugin.applied)
+	}
+
+	if out := serve(a, "POST", "/a/", nil, nil); out.Body.String() != "plugin" {
+		t.Errorf("Plugin not applied: %s", out.Body.String())
+	}
+	if out := serve(a, "GET", "/b/", nil, nil); out.Body.String() != "" {
+		t.Errorf("Skipped plugin applied: %s", out.Body.String())
+	}
+}
+
+func TestRouteOptionsPerMethod(t *testing.T) {
+	a := uweb.NewApp()
+	a.Get("^(items)/$", func(s string) string { return "lower priority" })
+	a.RouteWithOptions("^items/$", "GET", traceView, &uweb.RouteOptions{
+		Priority:   1,
+		Middleware: []uweb.Middleware{tracer("get")},
+	})
+	a.RouteWithOptions("^items/$", "POST", traceView, &uweb.RouteOptions{
+		Middleware: []uweb.Middleware{tracer("post")},
+	})
+
+	if out := serve(a, "GET", "/items/", nil, nil); out.Body.String() != "get" {
+		t.Errorf("Unexpected GET response: %s", out.Body.String())
+	}
+	if out := serve(a, "POST", "/items/", nil, nil); out.Body.String() != "post" {
+		t.Errorf("Unexpected POST response: %s", out.Body.String())
+	}
+
+	err := a.RouteWithOptions("^items/$", "PUT", traceView, &uweb.RouteOptions{Priority: 2})
+	if err == nil {
+		t.Error("Conflicting priority accepted")
+	}
+	a.RouteWithOptions("^items/$", "PATCH", traceView, &uweb.RouteOptions{
+		CORS: &uweb.CORSOptions{AllowOrigins: []string{"https://example.com"}},
+	})
+	err = a.RouteWithOptions("^items/$", "DELETE", traceView, &uweb.RouteOptions{
+		CORS: &uweb.CORSOptions{AllowOrigins: []string{"https://example.org"}},
+	})
+	if err == nil {
+		t.Error("Conflicting CORS policy accepted")
+	}
+	if out := serve(a, "PUT", "/items/", nil, nil); out.Code != 405 {
+		t.Errorf("Rejected target added: %d", out.Code)
+	}
+}
+
+// stamp sets the X-Stamp header on every response, including those written
+// before the target returns.
+func stamp(next uweb.Handler) uweb.Handler {
+	return uweb.HandlerFunc(func(ctx *uweb.Context) *uweb.Response {
+		ctx.OnBeforeWrite(func() {
+			ctx.Response.Header().Set("X-Stamp", "yes")
+		})
+		resp := next.Handle(ctx)
+		if resp != nil {
+			resp.Header().Set("X-Stamp", "yes")
+		}
+		return resp
+	})
+}
+
+func TestMiddlewareBeforeWrite(t *testing.T) {
+	a := uweb.NewApp()
+	a.Use(stamp)
+	a.Get("^returned/$", func() string { return "returned" })
+	a.Get("^streamed/$", func(ctx *uweb.Context) {
+		ctx.Stream().Write([]byte("streamed"))
+	})
+	a.Mount("^wrapped/", uweb.HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("wrapped"))
+	})))
+
+	for _, path := range []string{"/returned/", "/streamed/", "/wrapped/"} {
+		out := serve(a, "GET", path, nil, nil)
+		if out.Header().Get("X-Stamp") != "yes" {
+			t.Errorf("%s: header not set: %v", path, out.Header())
+		}
+	}
+}
@@ -0,0 +1,265 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"mime"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+/*
+An Encoder converts a value returned by a Target into the content of a
+response.
+
+An Encoder that doesn't support the value returns ErrCannotEncode, in which
+case the next best encoder accepted by the client is tried.
+
+	uweb.RegisterEncoder("application/yaml", func(ctx *uweb.Context, v interface{}) ([]byte, error) {
+		return yaml.Marshal(v)
+	})
+*/
+type Encoder func(ctx *Context, v interface{}) ([]byte, error)
+
+// ErrCannotEncode is returned by an Encoder that doesn't support a value.
+var ErrCannotEncode = errors.New("uweb: value can't be encoded")
+
+type encoderEntry struct {
+	contentType string
+	mediaType   string
+	encode      Encoder
+}
+
+var (
+	encodersLock sync.RWMutex
+	encoders     []encoderEntry
+)
+
+/*
+RegisterEncoder registers the encoder used for values returned by Targets
+when the client accepts contentType. It replaces any encoder already
+registered for the media type.
+
+When a Target returns a value that isn't a string, []byte, *Response,
+io.Reader or event stream, the encoder is chosen by the request's Accept
+header. Encoders with the highest quality are tried first. Among those with
+the same quality, encoders for media types the client names are tried before
+those it only accepts through a wildcard, and the rest in the order they
+were registered. If no Accept header is sent the first encoder that supports
+the value is used. The request is aborted with a 406 error if none of the
+encoders the client accepts support the value.
+
+By default there are encoders for, in order:
+
+	application/json  any value that json.Marshal supports
+	application/xml   any value that xml.Marshal supports
+	text/plain        strings, numbers, bools and fmt.Stringers
+	text/csv          slices of structs, with a header row of field names
+	                  taken from their "csv" tags
+	text/html         values with a template registered by
+	                  RegisterHTMLTemplate
+*/
+func RegisterEncoder(contentType string, encoder Encoder) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid content type %s for encoder", contentType))
+	}
+	encodersLock.Lock()
+	defer encodersLock.Unlock()
+	for i, entry := range encoders {
+		if entry.mediaType == mediaType {
+			encoders[i] = encoderEntry{contentType, mediaType, encoder}
+			return
+		}
+	}
+	encoders = append(encoders, encoderEntry{contentType, mediaType, encoder})
+}
+
+// encodeResult sets the content of the Context's Response to the result of
+// a Target, encoded for the media type that suits the client best.
+func encodeResult(ctx *Context, result interface{}) {
+	encodersLock.RLock()
+	candidates := make([]encoderEntry, len(encoders))
+	copy(candidates, encoders)
+	encodersLock.RUnlock()
+
+	accept := ctx.Request.Header.Get("Accept")
+	if accept != "" {
+		candidates = acceptedEncoders(parseAccept(accept), candidates)
+	}
+
+	addVary(ctx.Response.Header(), "Accept")
+	for _, entry := range candidates {
+		content, err := entry.encode(ctx, result)
+		if err == ErrCannotEncode {
+			continue
+		}
+		if err != nil {
+			panic(err)
+		}
+		ctx.Response.Content = content
+		ctx.Response.Header().Set("Content-Type", entry.contentType)
+		return
+	}
+	if accept == "" {
+		panic("Unknown response type returned from view function")
+	}
+	Abort(406, "Not Acceptable")
+}
+
+// acceptedEncoders returns the encoders the client accepts in the order they
+// are tried: by the quality the client gives them, then those it names ahead
+// of those it only accepts through a wildcard, then in the order they were
+// registered.
+func acceptedEncoders(ranges []acceptRange, encoders []encoderEntry) []encoderEntry {
+	type candidate struct {
+		entry encoderEntry
+		q     float64
+		exact bool
+	}
+	var accepted []candidate
+	for _, entry := range encoders {
+		if q, exact := quality(ranges, entry.mediaType); q > 0 {
+			accepted = append(accepted, candidate{entry, q, exact})
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool {
+		if accepted[i].q != accepted[j].q {
+			return accepted[i].q > accepted[j].q
+		}
+		return accepted[i].exact && !accepted[j].exact
+	})
+	sorted := make([]encoderEntry, len(accepted))
+	for i, c := range accepted {
+		sorted[i] = c.entry
+	}
+	return sorted
+}
+
+func encodeJSON(ctx *Context, v interface{}) ([]byte, error) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return nil, ErrCannotEncode
+	}
+	return content, nil
+}
+
+func encodeXML(ctx *Context, v interface{}) ([]byte, error) {
+	content, err := xml.Marshal(v)
+	if err != nil {
+		return nil, ErrCannotEncode
+	}
+	return append([]byte(xml.Header), content...), nil
+}
+
+func encodeText(ctx *Context, v interface{}) ([]byte, error) {
+	if s, ok := v.(fmt.Stringer); ok {
+		return []byte(s.String()), nil
+	}
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return []byte(fmt.Sprint(v)), nil
+	}
+	return nil, ErrCannotEncode
+}
+
+func encodeCSV(ctx *Context, v interface{}) ([]byte, error) {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return nil, ErrCannotEncode
+	}
+	structType := value.Type().Elem()
+	if structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+	if structType.Kind() != reflect.Struct {
+		return nil, ErrCannotEncode
+	}
+
+	var header []string
+	var fields []int
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		name := field.Tag.Get("csv")
+		if field.PkgPath != "" || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		header = append(header, name)
+		fields = append(fields, i)
+	}
+
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Write(header)
+	for i := 0; i < value.Len(); i++ {
+		item := reflect.Indirect(value.Index(i))
+		record := make([]string, len(fields))
+		if item.IsValid() {
+			for j, field := range fields {
+				record[j] = fmt.Sprint(item.Field(field).Interface())
+			}
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	return b.Bytes(), w.Error()
+}
+
+var (
+	htmlTemplatesLock sync.RWMutex
+	htmlTemplates     = make(map[reflect.Type]*template.Template)
+)
+
+// RegisterHTMLTemplate registers the template used to render values with the
+// same type as example, or pointers to them, when a client accepts
+// text/html.
+//
+//	uweb.RegisterHTMLTemplate(User{}, template.Must(template.ParseFiles("user.html")))
+func RegisterHTMLTemplate(example interface{}, t *template.Template) {
+	htmlTemplatesLock.Lock()
+	defer htmlTemplatesLock.Unlock()
+	htmlTemplates[reflect.TypeOf(example)] = t
+}
+
+func encodeHTML(ctx *Context, v interface{}) ([]byte, error) {
+	t := reflect.TypeOf(v)
+	htmlTemplatesLock.RLock()
+	tmpl, ok := htmlTemplates[t]
+	if !ok && t != nil && t.Kind() == reflect.Ptr {
+		tmpl, ok = htmlTemplates[t.Elem()]
+	}
+	htmlTemplatesLock.RUnlock()
+	if !ok {
+		return nil, ErrCannotEncode
+	}
+
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func init() {
+	RegisterEncoder("application/json", encodeJSON)
+	RegisterEncoder("application/xml; charset=utf-8", encodeXML)
+	RegisterEncoder("text/plain; charset=utf-8", encodeText)
+	RegisterEncoder("text/csv; charset=utf-8", encodeCSV)
+	RegisterEncoder("text/html; charset=utf-8", encodeHTML)
+}
@@ -0,0 +1,101 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb_test
+
+import (
+	"github.com/calebbrown/uweb"
+	"html/template"
+	"strings"
+	"testing"
+)
+
+type book struct {
+	Title  string `xml:"title" csv:"title"`
+	Author string `xml:"author" csv:"author"`
+	Pages  int    `xml:"pages" csv:"-"`
+}
+
+type temperature float64
+
+func (t temperature) String() string {
+	return "21.5C"
+}
+
+func init() {
+	uweb.RegisterHTMLTemplate(book{}, template.Must(template.New("book").Parse(
+		"<h1>{{.Title}}</h1><p>{{.Author}}</p>")))
+	uweb.RegisterEncoder("application/vnd.test", func(ctx *uweb.Context, v interface{}) ([]byte, error) {
+		if b, ok := v.(*book); ok {
+			return []byte("test:" + b.Title), nil
+		}
+		return nil, uweb.ErrCannotEncode
+	})
+
+	negotiateApp := uweb.NewApp()
+	app.Mount("^negotiate/", negotiateApp)
+	negotiateApp.Get("^book/$", func() *book {
+		return &book{Title: "Dune", Author: "Frank Herbert", Pages: 412}
+	})
+	negotiateApp.Get("^books/$", func() []book {
+		return []book{{Title: "Dune", Author: "Frank Herbert"}, {Title: "Emma, a novel", Author: "Jane Austen"}}
+	})
+	negotiateApp.Get("^temperature/$", func() temperature {
+		return 21.5
+	})
+	negotiateApp.Get("^map/$", func() map[string]int {
+		return map[string]int{"a": 1}
+	})
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		url, accept, contentType, body string
+	}{
+		{"/negotiate/book/", "", "application/json", `{"Title":"Dune","Author":"Frank Herbert","Pages":412}`},
+		{"/negotiate/book/", "*/*", "application/json", `{"Title":"Dune","Author":"Frank Herbert","Pages":412}`},
+		{"/negotiate/book/", "application/xml", "application/xml; charset=utf-8",
+			`<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+				`<book><title>Dune</title><author>Frank Herbert</author><pages>412</pages></book>`},
+		{"/negotiate/book/", "text/html,application/xhtml+xml,*/*;q=0.8", "text/html; charset=utf-8",
+			"<h1>Dune</h1><p>Frank Herbert</p>"},
+		{"/negotiate/book/", "application/json;q=0.5, application/vnd.test", "application/vnd.test", "test:Dune"},
+		{"/negotiate/books/", "text/csv", "text/csv; charset=utf-8",
+			"title,author\nDune,Frank Herbert\n\"Emma, a novel\",Jane Austen\n"},
+		{"/negotiate/books/", "text/html, application/json;q=0.1", "application/json",
+			`[{"Title":"Dune","Author":"Frank Herbert","Pages":0},{"Title":"Emma, a novel","Author":"Jane Austen","Pages":0}]`},
+		{"/negotiate/temperature/", "text/*", "text/plain; charset=utf-8", "21.5C"},
+		{"/negotiate/map/", "application/xml, application/json;q=0.9", "application/json", `{"a":1}`},
+		{"/negotiate/map/", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "application/json", `{"a":1}`},
+		{"/negotiate/temperature/", "*/*, text/plain", "text/plain; charset=utf-8", "21.5C"},
+		{"/negotiate/book/", "text/csv, application/xml;q=0.5, application/json;q=0.4", "application/xml; charset=utf-8",
+			`<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+				`<book><title>Dune</title><author>Frank Herbert</author><pages>412</pages></book>`},
+	}
+
+	for _, test := range tests {
+		out := serve(app, "GET", test.url, nil, map[string]string{"Accept": test.accept})
+		if out.Code != 200 {
+			t.Errorf("%s %s: status code %d", test.url, test.accept, out.Code)
+		}
+		if ct := out.Header().Get("Content-Type"); ct != test.contentType {
+			t.Errorf("%s %s: unexpected content type %s", test.url, test.accept, ct)
+		}
+		if body := out.Body.String(); body != test.body {
+			t.Errorf("%s %s: unexpected body %s", test.url, test.accept, body)
+		}
+		if vary := out.Header().Get("Vary"); vary != "Accept" {
+			t.Errorf("%s %s: unexpected vary header %s", test.url, test.accept, vary)
+		}
+	}
+}
+
+func TestNotAcceptable(t *testing.T) {
+	for _, accept := range []string{"image/png", "text/csv", "application/json;q=0"} {
+		out := serve(app, "GET", "/negotiate/book/", nil, map[string]string{"Accept": accept})
+		if out.Code != 406 || !strings.Contains(out.Body.String(), "Not Acceptable") {
+			t.Errorf("%s: unexpected response %d %s", accept, out.Code, out.Body.String())
+		}
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// interceptWriter is the http.ResponseWriter given to net/http handlers run
+// inside µweb.
+//
+// Successful responses are passed straight through to the Context's Writer,
+// or buffered in a Response if it doesn't have one. Error responses (4xx and
+// 5xx) are held back so they can be rendered by the App's error handlers.
+type interceptWriter struct {
+	ctx         *Context
+	out         http.ResponseWriter
+	header      http.Header
+	code        int
+	wroteHeader bool
+	intercept   bool
+	sniff       bool
+	errBody     bytes.Buffer
+	resp        *Response
+}
+
+func newInterceptWriter(ctx *Context) *interceptWriter {
+	return &interceptWriter{
+		ctx:       ctx,
+		out:       ctx.Writer,
+		header:    make(http.Header),
+		intercept: true,
+	}
+}
+
+func (w *interceptWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *interceptWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.code = code
+	if w.intercept && code >= 400 {
+		return
+	}
+
+	var resp *Response
+	if w.intercept {
+		// headers and cookies set on the Context's Response by middleware
+		// are kept, but the handler's own headers take precedence
+		resp = w.ctx.Response
+		if w.header.Get("Content-Type") == "" {
+			// like net/http, the content's type is sniffed rather than
+			// given µweb's default
+			resp.Header().Del("Content-Type")
+		}
+		for k, values := range w.header {
+			resp.Header()[k] = values
+		}
+	} else {
+		// a response from µweb that already has everything in the header
+		resp = NewResponse()
+		resp.header = w.header
+	}
+	resp.Code = code
+	resp.Content = nil
+	w.resp = resp
+
+	if w.out != nil {
+		if w.intercept && resp.Header().Get("Content-Type") == "" {
+			// the header is sent with the first write, once the content
+			// can be sniffed
+			w.sniff = true
+			return
+		}
+		w.writeHeader(nil)
+	}
+}
+
+// writeHeader sends the header of the Response to the Context's Writer,
+// detecting its Content-Type from b if it is still to be sniffed.
+func (w *interceptWriter) writeHeader(b []byte) {
+	if w.intercept {
+		w.ctx.runBeforeWrite()
+	}
+	if w.sniff {
+		w.sniff = false
+		if len(b) > 0 && w.resp.Header().Get("Content-Type") == "" {
+			w.resp.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+	}
+	w.resp.writeHeader(w.out)
+	w.resp.written = true
+}
+
+func (w *interceptWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.resp == nil {
+		return w.errBody.Write(b)
+	}
+	if w.sniff {
+		w.writeHeader(b)
+	}
+	if !w.resp.written {
+		w.resp.Content = append(w.resp.Content, b...)
+		return len(b), nil
+	}
+	return w.out.Write(b)
+}
+
+// Flush implements http.Flusher.
+func (w *interceptWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.sniff {
+		w.writeHeader(nil)
+	}
+	if w.resp != nil && w.resp.written {
+		if f, ok := w.out.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+}
+
+// Hijack implements http.Hijacker, if the Context's Writer does.
+func (w *interceptWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.out.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("uweb: ResponseWriter doesn't support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil {
+		w.wroteHeader = true
+		w.sniff = false
+		w.resp = w.ctx.Response
+		w.resp.written = true
+	}
+	return conn, rw, err
+}
+
+// Response returns the response written by the handler.
+//
+// If the handler wrote an error it is raised as an ErrorResponse, so that it
+// is rendered by the App's error handlers.
+func (w *interceptWriter) Response() *Response {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.sniff {
+		w.writeHeader(nil)
+	}
+	if w.resp != nil {
+		if w.intercept && !w.resp.written && len(w.resp.Content) > 0 && w.resp.Header().Get("Content-Type") == "" {
+			w.resp.Header().Set("Content-Type", http.DetectContentType(w.resp.Content))
+		}
+		return w.resp
+	}
+
+	e := NewError(w.code, strings.TrimSpace(w.errBody.String()))
+	e.Content = []byte(http.StatusText(w.code))
+	// keep headers that describe the error, such as Allow and
+	// WWW-Authenticate, but not those that describe the handler's content
+	for k, values := range w.header {
+		switch k {
+		case "Content-Type", "Content-Length", "X-Content-Type-Options":
+		default:
+			w.ctx.Response.Header()[k] = values
+		}
+	}
+	panic(e)
+}
+
+// requestForPath returns a copy of the request with the URL path replaced
+// by the Context's path, which has the patterns of any mounts stripped.
+func requestForPath(ctx *Context) *http.Request {
+	r := new(http.Request)
+	*r = *ctx.Request
+	u := *r.URL
+	u.Path = "/" + ctx.Path
+	u.RawPath = ""
+	r.URL = &u
+	return r
+}
+
+/*
+HTTPHandler adapts a net/http Handler so it can be mounted in an App.
+
+The handler sees the path left after the mount's pattern is stripped. What
+it writes is passed straight through to the client, except for error
+responses (4xx and 5xx) which are rendered by the App's error handlers.
+
+	app.Mount("^static/", uweb.HTTPHandler(http.FileServer(http.Dir("static"))))
+*/
+func HTTPHandler(h http.Handler) Handler {
+	return HandlerFunc(func(ctx *Context) *Response {
+		w := newInterceptWriter(ctx)
+		h.ServeHTTP(w, requestForPath(ctx))
+		return w.Response()
+	})
+}
+
+/*
+HTTPMiddleware adapts net/http middleware so it can be used with App.Use or
+in RouteOptions.
+
+The request passed on by the middleware, including any changes it made to
+it, becomes the Context's Request. Responses written by the middleware itself
+are treated the same way as those written by a handler adapted with
+HTTPHandler.
+
+	app.Use(uweb.HTTPMiddleware(handlers.ProxyHeaders))
+*/
+func HTTPMiddleware(middleware func(http.Handler) http.Handler) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx *Context) *Response {
+			w := newInterceptWriter(ctx)
+			var result *Response
+			passed := false
+
+			inner := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				ctx.Request = r
+				wrapped := rw != http.ResponseWriter(w)
+				if wrapped {
+					// everything written through the middleware's writer
+					// from here on comes from µweb, and has already been
+					// through its error handling
+					w.intercept = false
+					writer := ctx.Writer
+					ctx.Writer = rw
+					defer func() { ctx.Writer = writer }()
+				} else {
+					for k, values := range w.header {
+						ctx.Response.Header()[k] = values
+					}
+				}
+
+				resp := next.Handle(ctx)
+				if !wrapped || resp == nil || resp.written {
+					// the response can be returned without writing it
+					if resp != nil && !wrapped {
+						for k, values := range w.header {
+							resp.Header()[k] = values
+						}
+					}
+					result, passed = resp, true
+					return
+				}
+				resp.WriteResponse(rw)
+			})
+
+			middleware(inner).ServeHTTP(w, ctx.Request)
+			if passed {
+				return result
+			}
+			return w.Response()
+		})
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb_test
+
+import (
+	"github.com/calebbrown/uweb"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func echoPathHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/missing":
+		http.NotFound(w, r)
+	case "/method":
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "no", http.StatusMethodNotAllowed)
+	case "/untyped":
+		w.Write([]byte("plain text"))
+	default:
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("path: "))
+		w.Write([]byte(r.URL.Path))
+	}
+}
+
+func setHeader(next uweb.Handler) uweb.Handler {
+	return uweb.HandlerFunc(func(ctx *uweb.Context) *uweb.Response {
+		ctx.Response.Header().Set("X-Middleware", "yes")
+		return next.Handle(ctx)
+	})
+}
+
+// upperWriter upper-cases everything written through it.
+type upperWriter struct {
+	http.ResponseWriter
+}
+
+func (w upperWriter) Write(b []byte) (int, error) {
+	return w.ResponseWriter.Write([]byte(strings.ToUpper(string(b))))
+}
+
+func upperMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(upperWriter{w}, r)
+	})
+}
+
+func tagMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("deny") != "" {
+			http.Error(w, "denied", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("X-Tag", "tagged")
+		r.Header.Set("X-Tag", "from-middleware")
+		next.ServeHTTP(w, r)
+	})
+}
+
+func init() {
+	httpApp := uweb.NewApp()
+	app.Mount("^http/", httpApp)
+	httpApp.Use(setHeader)
+	httpApp.Mount("^wrapped/(sub)/", uweb.HTTPHandler(http.HandlerFunc(echoPathHandler)))
+	httpApp.Error(404, func() string { return "custom 404" })
+	httpApp.Error(401, func() string { return "custom 401" })
+
+	httpApp.RouteWithOptions("^tagged/$", "GET", func(ctx *uweb.Context) string {
+		return ctx.Request.Header.Get("X-Tag")
+	}, &uweb.RouteOptions{
+		Middleware: []uweb.Middleware{uweb.HTTPMiddleware(tagMiddleware)},
+	})
+	httpApp.RouteWithOptions("^upper/$", "GET", simpleView1, &uweb.RouteOptions{
+		Middleware: []uweb.Middleware{uweb.HTTPMiddleware(upperMiddleware)},
+	})
+	httpApp.RouteWithOptions("^upper/missing/$", "GET", notFoundView, &uweb.RouteOptions{
+		Middleware: []uweb.Middleware{uweb.HTTPMiddleware(upperMiddleware)},
+	})
+}
+
+func TestHTTPHandler(t *testing.T) {
+	out := doSimpleRequest("GET", "/http/wrapped/sub/some/file.txt", nil)
+	if out.Body.String() != "path: /some/file.txt" {
+		t.Errorf("Unexpected body: %s", out.Body.String())
+	}
+	if out.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("Unexpected content type: %s", out.Header().Get("Content-Type"))
+	}
+	if out.Header().Get("X-Middleware") != "yes" {
+		t.Error("Header set by middleware was lost")
+	}
+
+	out = doSimpleRequest("GET", "/http/wrapped/sub/untyped", nil)
+	if out.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Errorf("Content type not sniffed: %s", out.Header().Get("Content-Type"))
+	}
+
+	out = doSimpleRequest("GET", "/http/wrapped/sub/missing", nil)
+	if out.Code != 404 || out.Body.String() != "custom 404" {
+		t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+	}
+
+	out = doSimpleRequest("GET", "/http/wrapped/sub/method", nil)
+	if out.Code != 405 || out.Header().Get("Allow") != "GET" {
+		t.Errorf("Unexpected response: %d %v", out.Code, out.Header())
+	}
+	if strings.Contains(out.Body.String(), "no") {
+		t.Error("Error body written by the handler was not replaced")
+	}
+}
+
+func TestHTTPHandlerWithoutWriter(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/http/wrapped/sub/buffered", nil)
+	ctx := uweb.NewContext(req)
+	ctx.Path = "http/wrapped/sub/buffered"
+
+	resp := app.Handle(ctx)
+	if string(resp.Content) != "path: /buffered" {
+		t.Errorf("Unexpected content: %s", resp.Content)
+	}
+
+	req, _ = http.NewRequest("GET", "/http/wrapped/sub/untyped", nil)
+	ctx = uweb.NewContext(req)
+	ctx.Path = "http/wrapped/sub/untyped"
+	resp = app.Handle(ctx)
+	if resp.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Errorf("Content type not sniffed: %s", resp.Header().Get("Content-Type"))
+	}
+}
+
+func TestHTTPMiddleware(t *testing.T) {
+	out := doSimpleRequest("GET", "/http/tagged/", nil)
+	if out.Body.String() != "from-middleware" {
+		t.Errorf("Request changes lost: %s", out.Body.String())
+	}
+	if out.Header().Get("X-Tag") != "tagged" || out.Header().Get("X-Middleware") != "yes" {
+		t.Errorf("Headers lost: %v", out.Header())
+	}
+
+	out = doSimpleRequest("GET", "/http/tagged/?deny=1", nil)
+	if out.Code != 401 || out.Body.String() != "custom 401" {
+		t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+	}
+
+	out = doSimpleRequest("GET", "/http/upper/", nil)
+	if out.Body.String() != "HELLO WORLD" {
+		t.Errorf("Writer wrapped by middleware not used: %s", out.Body.String())
+	}
+
+	out = doSimpleRequest("GET", "/http/upper/missing/", nil)
+	if out.Code != 404 || out.Body.String() != "CUSTOM 404" {
+		t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb
+
+import (
+	"regexp/syntax"
+	"unicode"
+)
+
+// compileOverlapProg compiles a url pattern into a program that can be
+// compared against the programs of other patterns.
+//
+// The pattern is wrapped so that, like regexp.FindStringSubmatch, it matches
+// anywhere in a path unless it is explicitly anchored.
+func compileOverlapProg(pattern string) (*syntax.Prog, error) {
+	re, err := syntax.Parse(`(?s:.*)(?:`+pattern+`)(?s:.*)`, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	return syntax.Compile(re.Simplify())
+}
+
+// overlapState is a pair of positions, one in each program, that can be
+// reached by consuming the same input.
+type overlapState struct {
+	pc1, pc2 uint32
+	begin    bool
+}
+
+// progsOverlap reports whether there is at least one string matched by both
+// programs.
+//
+// It walks the product of the two programs, only following rune
+// instructions that can consume the same rune, until both programs can
+// match at the same point.
+//
+// Word boundaries are assumed to always be satisfiable, so patterns that
+// rely on them may be reported as overlapping when they are not.
+func progsOverlap(p1, p2 *syntax.Prog) bool {
+	start := overlapState{uint32(p1.Start), uint32(p2.Start), true}
+	seen := map[overlapState]bool{start: true}
+	queue := []overlapState{start}
+
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+
+		if _, m1 := closure(p1, s.pc1, s.begin, true); m1 {
+			if _, m2 := closure(p2, s.pc2, s.begin, true); m2 {
+				return true
+			}
+		}
+
+		runes1, _ := closure(p1, s.pc1, s.begin, false)
+		runes2, _ := closure(p2, s.pc2, s.begin, false)
+		for _, i := range runes1 {
+			for _, j := range runes2 {
+				if !instsIntersect(&p1.Inst[i], &p2.Inst[j]) {
+					continue
+				}
+				next := overlapState{p1.Inst[i].Out, p2.Inst[j].Out, false}
+				if !seen[next] {
+					seen[next] = true
+					queue = append(queue, next)
+				}
+			}
+		}
+	}
+	return false
+}
+
+// closure follows the empty transitions from pc. It returns the rune
+// instructions that are reached and whether a match instruction is reached.
+//
+// begin and end describe whether the current position is at the beginning
+// or end of the input.
+func closure(p *syntax.Prog, pc uint32, begin, end bool) ([]uint32, bool) {
+	var runes []uint32
+	match := false
+	seen := make(map[uint32]bool)
+	stack := []uint32{pc}
+
+	for len(stack) > 0 {
+		pc := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[pc] {
+			continue
+		}
+		seen[pc] = true
+
+		inst := &p.Inst[pc]
+		switch inst.Op {
+		case syntax.InstAlt, syntax.InstAltMatch:
+			stack = append(stack, inst.Out, inst.Arg)
+		case syntax.InstCapture, syntax.InstNop:
+			stack = append(stack, inst.Out)
+		case syntax.InstEmptyWidth:
+			op := syntax.EmptyOp(inst.Arg)
+			if op&(syntax.EmptyBeginLine|syntax.EmptyBeginText) != 0 && !begin {
+				continue
+			}
+			if op&(syntax.EmptyEndLine|syntax.EmptyEndText) != 0 && !end {
+				continue
+			}
+			stack = append(stack, inst.Out)
+		case syntax.InstMatch:
+			match = true
+		case syntax.InstRune, syntax.InstRune1, syntax.InstRuneAny,
+			syntax.InstRuneAnyNotNL:
+			runes = append(runes, pc)
+		}
+	}
+	return runes, match
+}
+
+// instsIntersect reports whether there is a rune consumed by both
+// instructions.
+func instsIntersect(i, j *syntax.Inst) bool {
+	for _, r := range runeCandidates(i, j) {
+		if instMatchRune(i, r) && instMatchRune(j, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// runeCandidates returns the runes worth testing against two rune
+// instructions. Two sets of rune ranges intersect if and only if one of the
+// range boundaries is in both sets.
+func runeCandidates(insts ...*syntax.Inst) []rune {
+	candidates := []rune{'a'}
+	for _, inst := range insts {
+		for _, r := range inst.Rune {
+			candidates = append(candidates, r)
+			if syntax.Flags(inst.Arg)&syntax.FoldCase != 0 {
+				for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+					candidates = append(candidates, f)
+				}
+			}
+		}
+	}
+	return candidates
+}
+
+func instMatchRune(inst *syntax.Inst, r rune) bool {
+	switch inst.Op {
+	case syntax.InstRuneAny:
+		return true
+	case syntax.InstRuneAnyNotNL:
+		return r != '\n'
+	}
+	return inst.MatchRune(r)
+}
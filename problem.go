@@ -0,0 +1,71 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+)
+
+// problem is the RFC 7807 "problem details" for an ErrorResponse.
+//
+// The field errors of the response, and in Debug mode its stack, are added
+// as extension members.
+type problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+	Stack    string       `json:"stack,omitempty"`
+}
+
+// wantsProblem reports whether an error should be rendered as
+// application/problem+json rather than HTML. This is the case for routes
+// with the API option, and for clients that ask for JSON ahead of HTML.
+func wantsProblem(ctx *Context) bool {
+	if ctx.api {
+		return true
+	}
+	ranges := parseAccept(ctx.Request.Header.Get("Accept"))
+	html, _ := quality(ranges, "text/html")
+	for _, mediaType := range []string{"application/json", "application/problem+json"} {
+		if q, exact := quality(ranges, mediaType); exact && q > 0 && q >= html {
+			return true
+		}
+	}
+	return false
+}
+
+// problemErrorHandler renders an ErrorResponse as application/problem+json.
+func problemErrorHandler(ctx *Context, e *ErrorResponse) []reflect.Value {
+	p := problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(e.StatusCode()),
+		Status:   e.StatusCode(),
+		Detail:   string(e.Content),
+		Instance: ctx.Request.URL.Path,
+		Errors:   e.Fields,
+	}
+	if p.Title == "" {
+		p.Title = p.Detail
+	}
+	if p.Detail == p.Title {
+		p.Detail = ""
+	}
+	if Config.Debug && e.Stack != "" {
+		p.Detail = e.Message
+		p.Stack = e.Stack
+	}
+
+	content, err := json.Marshal(p)
+	if err != nil {
+		panic(err)
+	}
+	ctx.Response.Header().Set("Content-Type", "application/problem+json")
+	return []reflect.Value{reflect.ValueOf(content)}
+}
@@ -0,0 +1,127 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb_test
+
+import (
+	"encoding/json"
+	"github.com/calebbrown/uweb"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type problemDetails struct {
+	Type     string
+	Title    string
+	Status   int
+	Detail   string
+	Instance string
+	Errors   []uweb.FieldError
+	Stack    string
+}
+
+func init() {
+	problemApp := uweb.NewApp()
+	app.Mount("^problem/", problemApp)
+	problemApp.Get("^page/$", func() { uweb.Abort(403, "Members only") })
+	problemApp.Get("^panic/$", func() { panic("broken") })
+	problemApp.Get("^users/$", func(in listUsers) string { return "" })
+	problemApp.RouteWithOptions("^api/page/$", "GET", func() {
+		uweb.Abort(409, "Already exists")
+	}, &uweb.RouteOptions{API: true})
+
+	api := uweb.NewApp()
+	api.Get("^items/$", func() string { return "items" })
+	problemApp.MountWithOptions("^v1/", api, &uweb.RouteOptions{API: true})
+}
+
+// problem decodes the problem details in a response, or returns nil if the
+// response isn't a problem.
+func problem(out *httptest.ResponseRecorder) *problemDetails {
+	if out.Header().Get("Content-Type") != "application/problem+json" {
+		return nil
+	}
+	var p problemDetails
+	if err := json.Unmarshal(out.Body.Bytes(), &p); err != nil {
+		panic(err)
+	}
+	return &p
+}
+
+func TestProblemAccept(t *testing.T) {
+	for _, accept := range []string{
+		"application/json",
+		"application/problem+json, application/json;q=0.9",
+		"application/json, text/html;q=0.5",
+	} {
+		out := serve(app, "GET", "/problem/page/", nil, map[string]string{"Accept": accept})
+		p := problem(out)
+		if p == nil {
+			t.Errorf("%s: problem not returned: %d %s", accept, out.Code, out.Body.String())
+			continue
+		}
+		expected := problemDetails{
+			Type: "about:blank", Title: "Forbidden", Status: 403,
+			Detail: "Members only", Instance: "/problem/page/",
+		}
+		if out.Code != 403 || p.Type != expected.Type || p.Title != expected.Title ||
+			p.Status != expected.Status || p.Detail != expected.Detail || p.Instance != expected.Instance {
+			t.Errorf("%s: unexpected problem %d %+v", accept, out.Code, p)
+		}
+	}
+
+	for _, accept := range []string{
+		"",
+		"*/*",
+		"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+		"text/html, application/json;q=0.5",
+	} {
+		out := serve(app, "GET", "/problem/page/", nil, map[string]string{"Accept": accept})
+		p := problem(out)
+		if p != nil || out.Code != 403 || !strings.Contains(out.Body.String(), "<h1>403 Members only</h1>") {
+			t.Errorf("%s: unexpected response %d %s", accept, out.Code, out.Body.String())
+		}
+	}
+}
+
+func TestProblemAPIRoutes(t *testing.T) {
+	out := serve(app, "GET", "/problem/api/page/", nil, map[string]string{"Accept": "text/html"})
+	p := problem(out)
+	if p == nil || out.Code != 409 || p.Title != "Conflict" || p.Detail != "Already exists" {
+		t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+	}
+
+	out = doSimpleRequest("GET", "/problem/v1/missing/", nil)
+	p = problem(out)
+	if p == nil || out.Code != 404 || p.Instance != "/problem/v1/missing/" {
+		t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+	}
+}
+
+func TestProblemFields(t *testing.T) {
+	out := serve(app, "GET", "/problem/users/?page=x", nil, map[string]string{"Accept": "application/json"})
+	p := problem(out)
+	if p == nil || out.Code != 400 || p.Detail != "" || len(p.Errors) != 1 || p.Errors[0].Field != "page" {
+		t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+	}
+}
+
+func TestProblemDebug(t *testing.T) {
+	out := serve(app, "GET", "/problem/panic/", nil, map[string]string{"Accept": "application/json"})
+	p := problem(out)
+	if p == nil || p.Status != 500 || p.Detail != "" || p.Stack != "" {
+		t.Errorf("Unexpected response: %s", out.Body.String())
+	}
+
+	uweb.Config.Debug = true
+	defer func() {
+		uweb.Config.Debug = false
+	}()
+	out = serve(app, "GET", "/problem/panic/", nil, map[string]string{"Accept": "application/json"})
+	p = problem(out)
+	if p == nil || p.Detail != "broken" || p.Stack == "" {
+		t.Errorf("Unexpected response: %s", out.Body.String())
+	}
+}
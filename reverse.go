@@ -0,0 +1,111 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+)
+
+// Reverse builds a path that is matched by the route, using args to fill in
+// the route's capture groups in order.
+//
+// Parts of the pattern outside the capture groups must be literal text,
+// anchors, optional (?, *) expressions or alternations. Optional expressions
+// are left out of the path unless they contain a capture group, and the
+// first alternative is used unless another contains the capture groups.
+func (r *route) Reverse(args []string) (string, error) {
+	if n := r.re.NumSubexp(); n != len(args) {
+		return "", fmt.Errorf("route %s expects %d arguments, got %d", r, n, len(args))
+	}
+	tree, err := syntax.Parse(r.re.String(), syntax.Perl)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := reverseRegexp(tree, args, &b); err != nil {
+		return "", fmt.Errorf("can't reverse route %s: %s", r, err)
+	}
+	return b.String(), nil
+}
+
+func reverseRegexp(re *syntax.Regexp, args []string, b *bytes.Buffer) error {
+	switch re.Op {
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine,
+		syntax.OpBeginText, syntax.OpEndText, syntax.OpWordBoundary,
+		syntax.OpNoWordBoundary:
+		// matches the empty string
+	case syntax.OpQuest, syntax.OpStar:
+		if hasCapture(re.Sub[0]) {
+			return reverseRegexp(re.Sub[0], args, b)
+		}
+	case syntax.OpLiteral:
+		b.WriteString(string(re.Rune))
+	case syntax.OpCharClass:
+		if len(re.Rune) != 2 || re.Rune[0] != re.Rune[1] {
+			return fmt.Errorf("character class %s outside a capture group", re)
+		}
+		b.WriteRune(re.Rune[0])
+	case syntax.OpCapture:
+		arg := args[re.Cap-1]
+		valid, err := regexp.MatchString(`^(?:`+re.Sub[0].String()+`)$`, arg)
+		if err != nil {
+			return err
+		}
+		if !valid {
+			return fmt.Errorf("argument %d '%s' doesn't match %s", re.Cap, arg, re.Sub[0])
+		}
+		b.WriteString(arg)
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if err := reverseRegexp(sub, args, b); err != nil {
+				return err
+			}
+		}
+	case syntax.OpAlternate:
+		branch := re.Sub[0]
+		captures := 0
+		for _, sub := range re.Sub {
+			if hasCapture(sub) {
+				branch = sub
+				captures++
+			}
+		}
+		if captures > 1 {
+			return fmt.Errorf("capture groups in more than one alternative of %s", re)
+		}
+		return reverseRegexp(branch, args, b)
+	case syntax.OpPlus:
+		return reverseRegexp(re.Sub[0], args, b)
+	case syntax.OpRepeat:
+		n := re.Min
+		if n == 0 && re.Max != 0 && hasCapture(re.Sub[0]) {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			if err := reverseRegexp(re.Sub[0], args, b); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%s outside a capture group", re)
+	}
+	return nil
+}
+
+// hasCapture reports whether re contains a capture group.
+func hasCapture(re *syntax.Regexp) bool {
+	if re.Op == syntax.OpCapture {
+		return true
+	}
+	for _, sub := range re.Sub {
+		if hasCapture(sub) {
+			return true
+		}
+	}
+	return false
+}
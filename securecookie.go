@@ -0,0 +1,235 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalidCookie is returned for a secure cookie that has been changed,
+	// or wasn't created with any of the keys.
+	ErrInvalidCookie = errors.New("uweb: secure cookie is invalid or has been tampered with")
+
+	// ErrCookieExpired is returned for a secure cookie that is older than
+	// the maximum age it is read with.
+	ErrCookieExpired = errors.New("uweb: secure cookie has expired")
+)
+
+const (
+	signedCookie    = 's'
+	encryptedCookie = 'e'
+	timestampSize   = 8
+)
+
+// cookieKey holds the keys derived from one of the keys in a key ring, so a
+// key is never used both to sign and to encrypt.
+type cookieKey struct {
+	sign []byte
+	aead cipher.AEAD
+}
+
+// keyRing signs and encrypts values with its first key, and checks them
+// with any of its keys.
+type keyRing []cookieKey
+
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func newKeyRing(keys [][]byte) keyRing {
+	ring := make(keyRing, len(keys))
+	for i, key := range keys {
+		block, err := aes.NewCipher(deriveKey(key, "uweb encrypt"))
+		if err != nil {
+			panic(err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			panic(err)
+		}
+		ring[i] = cookieKey{sign: deriveKey(key, "uweb sign"), aead: aead}
+	}
+	return ring
+}
+
+var (
+	cookieKeysLock sync.Mutex
+	// the key ring made from Config.CookieKeys, and a copy of the keys so
+	// that it is made again when they change
+	cookieKeys    [][]byte
+	cookieKeyRing keyRing
+)
+
+// configKeyRing returns the key ring for Config.CookieKeys.
+func configKeyRing() keyRing {
+	keys := Config.CookieKeys
+	cookieKeysLock.Lock()
+	defer cookieKeysLock.Unlock()
+	if !sameKeys(keys, cookieKeys) {
+		cookieKeyRing = newKeyRing(keys)
+		cookieKeys = make([][]byte, len(keys))
+		for i, key := range keys {
+			cookieKeys[i] = append([]byte(nil), key...)
+		}
+	}
+	return cookieKeyRing
+}
+
+func sameKeys(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// signature returns the HMAC of a signed value. The name is included so a
+// value can't be moved to another cookie.
+func (k cookieKey) signature(name string, data []byte) []byte {
+	mac := hmac.New(sha256.New, k.sign)
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// encode returns value with a timestamp, signed or encrypted for the cookie
+// called name.
+func (r keyRing) encode(name string, value []byte, encrypt bool) string {
+	if len(r) == 0 {
+		panic("No keys for secure cookies, set Config.CookieKeys")
+	}
+	data := make([]byte, 1+timestampSize, 1+timestampSize+len(value))
+	binary.BigEndian.PutUint64(data[1:], uint64(time.Now().Unix()))
+	data = append(data, value...)
+
+	key := r[0]
+	if encrypt {
+		data[0] = encryptedCookie
+		nonce := make([]byte, key.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			panic(err)
+		}
+		sealed := key.aead.Seal(nonce, nonce, data[1:], []byte(name))
+		data = append(data[:1], sealed...)
+	} else {
+		data[0] = signedCookie
+		data = append(data, key.signature(name, data)...)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decode checks a value created by encode with any of the keys, and returns
+// what was encoded. Values older than maxAge are rejected, unless it is zero.
+func (r keyRing) decode(name, value string, maxAge time.Duration) ([]byte, error) {
+	// strict, so there is only one encoding of each value
+	data, err := base64.RawURLEncoding.Strict().DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, ErrInvalidCookie
+	}
+
+	var payload []byte
+	for _, key := range r {
+		payload = key.open(name, data)
+		if payload != nil {
+			break
+		}
+	}
+	if len(payload) < timestampSize {
+		return nil, ErrInvalidCookie
+	}
+
+	created := time.Unix(int64(binary.BigEndian.Uint64(payload)), 0)
+	if maxAge > 0 && time.Since(created) > maxAge {
+		return nil, ErrCookieExpired
+	}
+	return payload[timestampSize:], nil
+}
+
+// open returns the timestamp and value of data if it was signed or
+// encrypted with the key, otherwise nil.
+func (k cookieKey) open(name string, data []byte) []byte {
+	switch data[0] {
+	case signedCookie:
+		if len(data) < 1+timestampSize+sha256.Size {
+			return nil
+		}
+		signed, signature := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+		if hmac.Equal(signature, k.signature(name, signed)) {
+			return signed[1:]
+		}
+	case encryptedCookie:
+		nonceSize := k.aead.NonceSize()
+		if len(data) < 1+nonceSize {
+			return nil
+		}
+		nonce, sealed := data[1:1+nonceSize], data[1+nonceSize:]
+		if payload, err := k.aead.Open(nil, nonce, sealed, []byte(name)); err == nil {
+			return payload
+		}
+	}
+	return nil
+}
+
+/*
+SetSecureCookie sets a cookie, like SetCookie, whose value is signed with
+Config.CookieKeys so that it can't be changed by the client. If
+Config.EncryptCookies is set the value is also encrypted, so the client can't
+read it either. It panics if there are no keys.
+
+The time the cookie was set is included in the value, so it can be read with
+a maximum age by Context.GetSecureCookie.
+
+	uweb.Config.CookieKeys = [][]byte{newKey, oldKey}
+
+	ctx.Response.SetSecureCookie("user", "42")
+	...
+	user, err := ctx.GetSecureCookie("user", 24*time.Hour)
+*/
+func (r *Response) SetSecureCookie(name, value string) {
+	r.SetSecureCookieWithOptions(name, value, Config.CookieOptions)
+}
+
+// SetSecureCookieWithOptions sets a secure cookie, like SetSecureCookie,
+// using the options.
+func (r *Response) SetSecureCookieWithOptions(name, value string, options *CookieOptions) {
+	encoded := configKeyRing().encode(name, []byte(value), Config.EncryptCookies)
+	r.SetCookieWithOptions(name, encoded, options)
+}
+
+// GetSecureCookie returns the value of a cookie set by
+// Response.SetSecureCookie. It returns ErrInvalidCookie if the value has been
+// changed or wasn't set with any of Config.CookieKeys, and ErrCookieExpired
+// if it was set longer than maxAge ago. A maxAge of zero means the cookie
+// doesn't expire.
+func (c *Context) GetSecureCookie(name string, maxAge time.Duration) (string, error) {
+	value, err := c.GetCookie(name)
+	if err != nil {
+		return "", err
+	}
+	decoded, err := configKeyRing().decode(name, value, maxAge)
+	if err != nil {
+		return "", fmt.Errorf("cookie '%s': %w", name, err)
+	}
+	return string(decoded), nil
+}
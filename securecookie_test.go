@@ -0,0 +1,132 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb_test
+
+import (
+	"errors"
+	"github.com/calebbrown/uweb"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setCookieKeys(t *testing.T, encrypt bool, keys ...string) {
+	oldKeys, oldEncrypt := uweb.Config.CookieKeys, uweb.Config.EncryptCookies
+	t.Cleanup(func() {
+		uweb.Config.CookieKeys, uweb.Config.EncryptCookies = oldKeys, oldEncrypt
+	})
+	uweb.Config.CookieKeys = nil
+	for _, key := range keys {
+		uweb.Config.CookieKeys = append(uweb.Config.CookieKeys, []byte(key))
+	}
+	uweb.Config.EncryptCookies = encrypt
+}
+
+// setSecureCookie returns the cookie set by SetSecureCookie.
+func setSecureCookie(name, value string) *http.Cookie {
+	resp := uweb.NewResponse()
+	resp.SetSecureCookie(name, value)
+	return resp.Cookies[name]
+}
+
+// getSecureCookie reads a cookie with GetSecureCookie.
+func getSecureCookie(cookie *http.Cookie, maxAge time.Duration) (string, error) {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.AddCookie(cookie)
+	return uweb.NewContext(req).GetSecureCookie(cookie.Name, maxAge)
+}
+
+func TestSecureCookie(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		setCookieKeys(t, encrypt, "secret")
+		cookie := setSecureCookie("user", "alice")
+		if !cookie.HttpOnly || cookie.Path != "/" {
+			t.Errorf("Cookie options not used: %v", cookie)
+		}
+		if strings.Contains(cookie.Value, "alice") {
+			t.Errorf("Value not encoded: %s", cookie.Value)
+		}
+		value, err := getSecureCookie(cookie, time.Hour)
+		if err != nil || value != "alice" {
+			t.Errorf("encrypt %v: unexpected value %q %v", encrypt, value, err)
+		}
+
+		// a value can't be used for another cookie
+		moved := *cookie
+		moved.Name = "admin"
+		if _, err := getSecureCookie(&moved, 0); !errors.Is(err, uweb.ErrInvalidCookie) {
+			t.Errorf("encrypt %v: moved cookie accepted: %v", encrypt, err)
+		}
+
+		for i := range cookie.Value {
+			tampered := *cookie
+			b := []byte(cookie.Value)
+			b[i] = "AB"[b[i]%2]
+			tampered.Value = string(b)
+			if tampered.Value == cookie.Value {
+				continue
+			}
+			if _, err := getSecureCookie(&tampered, 0); !errors.Is(err, uweb.ErrInvalidCookie) {
+				t.Fatalf("encrypt %v: tampered cookie accepted: %v", encrypt, err)
+			}
+		}
+	}
+
+	plain := &http.Cookie{Name: "user", Value: "alice"}
+	_, err := getSecureCookie(plain, 0)
+	if !errors.Is(err, uweb.ErrInvalidCookie) || !strings.Contains(err.Error(), "'user'") {
+		t.Errorf("Unexpected error for a plain cookie: %v", err)
+	}
+}
+
+func TestSecureCookieKeyRotation(t *testing.T) {
+	setCookieKeys(t, false, "old")
+	signed := setSecureCookie("user", "alice")
+	uweb.Config.EncryptCookies = true
+	encrypted := setSecureCookie("user", "bob")
+
+	setCookieKeys(t, false, "new", "old")
+	if value, err := getSecureCookie(signed, 0); err != nil || value != "alice" {
+		t.Errorf("Signed cookie not checked with old key: %q %v", value, err)
+	}
+	if value, err := getSecureCookie(encrypted, 0); err != nil || value != "bob" {
+		t.Errorf("Encrypted cookie not decrypted with old key: %q %v", value, err)
+	}
+
+	setCookieKeys(t, false, "new")
+	if _, err := getSecureCookie(signed, 0); !errors.Is(err, uweb.ErrInvalidCookie) {
+		t.Errorf("Cookie accepted after its key was removed: %v", err)
+	}
+
+	// keys changed in place are noticed too
+	setCookieKeys(t, false, "old")
+	uweb.Config.CookieKeys[0][0] = 'O'
+	if _, err := getSecureCookie(signed, 0); !errors.Is(err, uweb.ErrInvalidCookie) {
+		t.Errorf("Cookie accepted after its key was changed: %v", err)
+	}
+}
+
+func TestSecureCookieMaxAge(t *testing.T) {
+	setCookieKeys(t, true, "secret")
+	cookie := setSecureCookie("user", "alice")
+	time.Sleep(1100 * time.Millisecond)
+	if _, err := getSecureCookie(cookie, time.Second); !errors.Is(err, uweb.ErrCookieExpired) {
+		t.Errorf("Expired cookie accepted: %v", err)
+	}
+	if value, err := getSecureCookie(cookie, 0); err != nil || value != "alice" {
+		t.Errorf("Cookie without max age rejected: %q %v", value, err)
+	}
+}
+
+func TestSecureCookieWithoutKeys(t *testing.T) {
+	setCookieKeys(t, false)
+	defer func() {
+		if recover() == nil {
+			t.Error("Secure cookie set without keys")
+		}
+	}()
+	setSecureCookie("user", "alice")
+}
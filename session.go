@@ -0,0 +1,274 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"time"
+)
+
+// A Session holds values that are kept between a client's requests.
+//
+// Values can be read and changed directly, but changes made without Set or
+// Delete must be followed by a call to Changed so the session is saved.
+//
+// Created is when the session was started, and Accessed when it was last
+// saved. Expires is when the session expires, or zero if it doesn't.
+type Session struct {
+	ID       string
+	Values   map[string]interface{}
+	Created  time.Time
+	Accessed time.Time
+	Expires  time.Time
+
+	changed   bool
+	destroyed bool
+	oldID     string
+}
+
+// Get returns the value stored under key, or nil if there isn't one.
+func (s *Session) Get(key string) interface{} {
+	return s.Values[key]
+}
+
+// Set stores a value under key.
+func (s *Session) Set(key string, value interface{}) {
+	s.Values[key] = value
+	s.changed = true
+}
+
+// Delete removes the value stored under key.
+func (s *Session) Delete(key string) {
+	if _, ok := s.Values[key]; ok {
+		delete(s.Values, key)
+		s.changed = true
+	}
+}
+
+// Changed marks the session as changed so that it is saved at the end of the
+// request.
+func (s *Session) Changed() {
+	s.changed = true
+}
+
+// Rotate gives the session a new ID, keeping its values. It should be called
+// when a user logs in, so that an ID fixed by an attacker beforehand can't be
+// used to take over the session.
+func (s *Session) Rotate() {
+	if s.oldID == "" {
+		s.oldID = s.ID
+	}
+	s.ID = newSessionID()
+	s.changed = true
+}
+
+// Destroy removes the session from its store and deletes its cookie. Values
+// set afterwards are saved in a new session.
+func (s *Session) Destroy() {
+	s.Rotate()
+	s.Values = make(map[string]interface{})
+	s.Created = time.Now()
+	s.destroyed = true
+	s.changed = false
+}
+
+func newSessionID() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func newSession() *Session {
+	now := time.Now()
+	return &Session{
+		ID:       newSessionID(),
+		Values:   make(map[string]interface{}),
+		Created:  now,
+		Accessed: now,
+	}
+}
+
+/*
+A SessionStore saves sessions between requests.
+
+Load returns the session for the value of the session cookie, or nil if
+there isn't one. Save stores a session and returns the value for the cookie.
+Delete removes the session with the given ID. Stores must be safe to use from
+several goroutines at once.
+
+Stores that keep sessions on the server should remove them once they have
+expired.
+*/
+type SessionStore interface {
+	Load(value string) (*Session, error)
+	Save(session *Session) (string, error)
+	Delete(id string) error
+}
+
+// SessionOptions holds the settings for sessions.
+//
+// CookieName is the name of the session cookie. It defaults to "session".
+// CookieOptions are used for the cookie, and default to
+// Config.CookieOptions.
+//
+// IdleTimeout expires a session that hasn't been used for that long, and
+// MaxAge expires a session that long after it was created, however much it
+// is used. Zero means the session doesn't expire for that reason.
+type SessionOptions struct {
+	CookieName    string
+	CookieOptions *CookieOptions
+	IdleTimeout   time.Duration
+	MaxAge        time.Duration
+}
+
+// sessionManager loads and saves the session for a request.
+type sessionManager struct {
+	store   SessionStore
+	options SessionOptions
+	ctx     *Context
+	loaded  *Session
+	touched bool
+}
+
+/*
+Sessions creates Middleware that gives requests a session kept in store,
+which targets get from Context.Session.
+
+A session is only loaded when Context.Session is called, and only saved if it
+has changed, or to keep an idle session alive. It is saved when the target
+returns, or before the headers are sent if the target streams its response.
+
+	app.Use(uweb.Sessions(uweb.NewMemoryStore(), &uweb.SessionOptions{
+		IdleTimeout: 30 * time.Minute,
+		MaxAge:      24 * time.Hour,
+	}))
+
+	func Login(ctx *uweb.Context) {
+		...
+		session := ctx.Session()
+		session.Rotate()
+		session.Set("user", user.ID)
+	}
+*/
+func Sessions(store SessionStore, options *SessionOptions) Middleware {
+	o := SessionOptions{}
+	if options != nil {
+		o = *options
+	}
+	if o.CookieName == "" {
+		o.CookieName = "session"
+	}
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx *Context) *Response {
+			m := &sessionManager{store: store, options: o, ctx: ctx}
+			ctx.sessions = m
+			ctx.OnBeforeWrite(m.save)
+			resp := next.Handle(ctx)
+			m.save()
+			keepCookie(ctx, resp, o.CookieName)
+			return resp
+		})
+	}
+}
+
+// Session returns the request's session, starting a new one if the client
+// doesn't have one. It panics if the App doesn't use the Sessions
+// middleware.
+func (c *Context) Session() *Session {
+	if c.sessions == nil {
+		panic("Sessions middleware not in use")
+	}
+	return c.sessions.load()
+}
+
+func (m *sessionManager) load() *Session {
+	if m.loaded != nil {
+		return m.loaded
+	}
+	if value, err := m.ctx.GetCookie(m.options.CookieName); err == nil && value != "" {
+		session, err := m.store.Load(value)
+		if err != nil {
+			debugf("Failed to load session: %s", err)
+		}
+		if session != nil && m.expired(session) {
+			m.store.Delete(session.ID)
+			session = nil
+		}
+		m.loaded = session
+	}
+	if m.loaded == nil {
+		m.loaded = newSession()
+		return m.loaded
+	}
+	if m.loaded.Values == nil {
+		m.loaded.Values = make(map[string]interface{})
+	}
+	// keep an idle session alive, without saving it on every request
+	if m.options.IdleTimeout > 0 && time.Since(m.loaded.Accessed) > m.options.IdleTimeout/4 {
+		m.touched = true
+	}
+	return m.loaded
+}
+
+func (m *sessionManager) expired(s *Session) bool {
+	now := time.Now()
+	if m.options.MaxAge > 0 && now.Sub(s.Created) > m.options.MaxAge {
+		return true
+	}
+	if m.options.IdleTimeout > 0 && now.Sub(s.Accessed) > m.options.IdleTimeout {
+		return true
+	}
+	return !s.Expires.IsZero() && now.After(s.Expires)
+}
+
+func (m *sessionManager) cookieOptions() *CookieOptions {
+	if m.options.CookieOptions != nil {
+		return m.options.CookieOptions
+	}
+	return Config.CookieOptions
+}
+
+// save saves the session if it has changed, and sets the cookie on the
+// Context's Response.
+func (m *sessionManager) save() {
+	s := m.loaded
+	if s == nil {
+		return
+	}
+	if s.oldID != "" {
+		if err := m.store.Delete(s.oldID); err != nil {
+			panic(err)
+		}
+		s.oldID = ""
+	}
+	if s.destroyed && !s.changed {
+		s.destroyed = false
+		m.ctx.Response.DeleteCookieWithOptions(m.options.CookieName, m.cookieOptions())
+		return
+	}
+	if !s.changed && !m.touched {
+		return
+	}
+
+	s.Accessed = time.Now()
+	s.Expires = time.Time{}
+	if m.options.MaxAge > 0 {
+		s.Expires = s.Created.Add(m.options.MaxAge)
+	}
+	if m.options.IdleTimeout > 0 {
+		if idle := s.Accessed.Add(m.options.IdleTimeout); s.Expires.IsZero() || idle.Before(s.Expires) {
+			s.Expires = idle
+		}
+	}
+	value, err := m.store.Save(s)
+	if err != nil {
+		panic(err)
+	}
+	s.changed, s.destroyed, m.touched = false, false, false
+	m.ctx.Response.SetCookieWithOptions(m.options.CookieName, value, m.cookieOptions())
+}
@@ -0,0 +1,226 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb_test
+
+import (
+	"fmt"
+	"github.com/calebbrown/uweb"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sessionStore and sessionOptions are used by the Sessions middleware of the
+// sessions/ routes.
+var (
+	sessionStore   uweb.SessionStore
+	sessionOptions *uweb.SessionOptions
+)
+
+func init() {
+	sessionApp := uweb.NewApp()
+	app.Mount("^sessions/", sessionApp)
+	sessionApp.Use(func(next uweb.Handler) uweb.Handler {
+		return uweb.HandlerFunc(func(ctx *uweb.Context) *uweb.Response {
+			return uweb.Sessions(sessionStore, sessionOptions)(next).Handle(ctx)
+		})
+	})
+	sessionApp.Get("^get/$", func(ctx *uweb.Context) string {
+		return fmt.Sprint(ctx.Session().Get("user"))
+	})
+	sessionApp.Get("^set/([a-z]+)/$", func(ctx *uweb.Context, user string) string {
+		ctx.Session().Set("user", user)
+		return "ok"
+	})
+	sessionApp.Get("^login/([a-z]+)/$", func(ctx *uweb.Context, user string) string {
+		s := ctx.Session()
+		s.Rotate()
+		s.Set("user", user)
+		return "ok"
+	})
+	sessionApp.Get("^logout/$", func(ctx *uweb.Context) string {
+		ctx.Session().Destroy()
+		return "ok"
+	})
+	sessionApp.Get("^stream/$", func(ctx *uweb.Context) {
+		ctx.Session().Set("user", "streamer")
+		fmt.Fprint(ctx.Stream(), "streamed")
+	})
+	sessionApp.Get("^none/$", func() string {
+		return "none"
+	})
+}
+
+// sessionCookie returns the session cookie sent with a response, or nil if
+// none was sent.
+func sessionCookie(out *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range out.Result().Cookies() {
+		if c.Name == "session" {
+			return c
+		}
+	}
+	return nil
+}
+
+func sessionStores(t *testing.T) map[string]uweb.SessionStore {
+	dir, err := ioutil.TempDir("", "uweb-sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return map[string]uweb.SessionStore{
+		"cookie": uweb.NewCookieStore([]byte("a secret key")),
+		"memory": uweb.NewMemoryStore(),
+		"file":   uweb.NewFileStore(dir),
+	}
+}
+
+func TestSessions(t *testing.T) {
+	for name, store := range sessionStores(t) {
+		sessionStore, sessionOptions = store, nil
+
+		// sessions are only saved when they change
+		if c := sessionCookie(doSimpleRequest("GET", "/sessions/get/", nil)); c != nil {
+			t.Errorf("%s: unchanged session saved", name)
+		}
+		if c := sessionCookie(doSimpleRequest("GET", "/sessions/none/", nil)); c != nil {
+			t.Errorf("%s: unused session saved", name)
+		}
+
+		cookie := sessionCookie(doSimpleRequest("GET", "/sessions/set/alice/", nil))
+		if cookie == nil {
+			t.Fatalf("%s: no session cookie set", name)
+		}
+		if !cookie.HttpOnly || cookie.Path != "/" {
+			t.Errorf("%s: cookie options not used: %v", name, cookie)
+		}
+		out := serve(app, "GET", "/sessions/get/", nil, cookieHeader(cookie))
+		c := sessionCookie(out)
+		if out.Body.String() != "alice" {
+			t.Errorf("%s: unexpected session value %s", name, out.Body.String())
+		}
+		if c != nil {
+			t.Errorf("%s: unchanged session saved", name)
+		}
+
+		// a streamed response still gets the cookie
+		out = serve(app, "GET", "/sessions/stream/", nil, cookieHeader(cookie))
+		c = sessionCookie(out)
+		if out.Body.String() != "streamed" || c == nil {
+			t.Fatalf("%s: streamed response without cookie", name)
+		}
+		if out := serve(app, "GET", "/sessions/get/", nil, cookieHeader(c)); out.Body.String() != "streamer" {
+			t.Errorf("%s: streamed session not saved: %s", name, out.Body.String())
+		}
+
+		// an unknown or tampered cookie starts a new session
+		bad := &http.Cookie{Name: "session", Value: strings.Repeat("A", 43)}
+		if out := serve(app, "GET", "/sessions/get/", nil, cookieHeader(bad)); out.Body.String() != "<nil>" {
+			t.Errorf("%s: invalid cookie loaded %s", name, out.Body.String())
+		}
+	}
+}
+
+func TestSessionRotate(t *testing.T) {
+	for name, store := range sessionStores(t) {
+		sessionStore, sessionOptions = store, nil
+
+		before := sessionCookie(doSimpleRequest("GET", "/sessions/set/guest/", nil))
+		after := sessionCookie(serve(app, "GET", "/sessions/login/bob/", nil, cookieHeader(before)))
+		if after == nil || after.Value == before.Value {
+			t.Fatalf("%s: session not rotated", name)
+		}
+		if out := serve(app, "GET", "/sessions/get/", nil, cookieHeader(after)); out.Body.String() != "bob" {
+			t.Errorf("%s: rotated session lost: %s", name, out.Body.String())
+		}
+		if name != "cookie" {
+			if out := serve(app, "GET", "/sessions/get/", nil, cookieHeader(before)); out.Body.String() != "<nil>" {
+				t.Errorf("%s: old session ID still valid: %s", name, out.Body.String())
+			}
+		}
+
+		deleted := sessionCookie(serve(app, "GET", "/sessions/logout/", nil, cookieHeader(after)))
+		if deleted == nil || deleted.MaxAge >= 0 {
+			t.Fatalf("%s: session cookie not deleted: %v", name, deleted)
+		}
+		if name != "cookie" {
+			if out := serve(app, "GET", "/sessions/get/", nil, cookieHeader(after)); out.Body.String() != "<nil>" {
+				t.Errorf("%s: destroyed session still valid: %s", name, out.Body.String())
+			}
+		}
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	for name, store := range sessionStores(t) {
+		sessionStore, sessionOptions = store, &uweb.SessionOptions{IdleTimeout: 100 * time.Millisecond}
+		cookie := sessionCookie(doSimpleRequest("GET", "/sessions/set/alice/", nil))
+
+		// using the session keeps it alive
+		time.Sleep(60 * time.Millisecond)
+		out := serve(app, "GET", "/sessions/get/", nil, cookieHeader(cookie))
+		c := sessionCookie(out)
+		if out.Body.String() != "alice" || c == nil {
+			t.Fatalf("%s: idle session not kept alive: %s", name, out.Body.String())
+		}
+		time.Sleep(60 * time.Millisecond)
+		if out := serve(app, "GET", "/sessions/get/", nil, cookieHeader(c)); out.Body.String() != "alice" {
+			t.Errorf("%s: session expired while in use", name)
+		}
+		time.Sleep(150 * time.Millisecond)
+		if out := serve(app, "GET", "/sessions/get/", nil, cookieHeader(c)); out.Body.String() != "<nil>" {
+			t.Errorf("%s: idle session not expired", name)
+		}
+
+		sessionOptions = &uweb.SessionOptions{
+			IdleTimeout: time.Hour,
+			MaxAge:      100 * time.Millisecond,
+		}
+		cookie = sessionCookie(doSimpleRequest("GET", "/sessions/set/alice/", nil))
+		time.Sleep(150 * time.Millisecond)
+		if out := serve(app, "GET", "/sessions/get/", nil, cookieHeader(cookie)); out.Body.String() != "<nil>" {
+			t.Errorf("%s: session not expired after MaxAge", name)
+		}
+	}
+}
+
+func TestCookieStoreKeys(t *testing.T) {
+	old := uweb.NewCookieStore([]byte("old key"))
+	sessionStore, sessionOptions = old, nil
+	cookie := sessionCookie(doSimpleRequest("GET", "/sessions/set/alice/", nil))
+
+	sessionStore = uweb.NewCookieStore([]byte("new key"), []byte("old key"))
+	if out := serve(app, "GET", "/sessions/get/", nil, cookieHeader(cookie)); out.Body.String() != "alice" {
+		t.Errorf("Session not decrypted with old key: %s", out.Body.String())
+	}
+	sessionStore = uweb.NewCookieStore([]byte("other key"))
+	if out := serve(app, "GET", "/sessions/get/", nil, cookieHeader(cookie)); out.Body.String() != "<nil>" {
+		t.Errorf("Session decrypted with the wrong key: %s", out.Body.String())
+	}
+
+	tampered := *cookie
+	b := []byte(tampered.Value)
+	b[len(b)/2] ^= 1
+	tampered.Value = string(b)
+	sessionStore = old
+	if out := serve(app, "GET", "/sessions/get/", nil, cookieHeader(&tampered)); out.Body.String() != "<nil>" {
+		t.Errorf("Tampered session loaded: %s", out.Body.String())
+	}
+}
+
+func TestSessionWithoutMiddleware(t *testing.T) {
+	a := uweb.NewApp()
+	a.Get("^$", func(ctx *uweb.Context) string {
+		ctx.Session()
+		return "ok"
+	})
+	if out := serve(a, "GET", "/", nil, nil); out.Code != 500 {
+		t.Errorf("Unexpected response: %d", out.Code)
+	}
+}
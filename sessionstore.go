@@ -0,0 +1,266 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how often server side stores remove expired sessions
+const sessionSweepInterval = 10 * time.Minute
+
+// encodeSession and decodeSession convert sessions to and from bytes. Values
+// of types other than the basic ones must be registered with gob.Register.
+func encodeSession(s *Session) ([]byte, error) {
+	var b bytes.Buffer
+	err := gob.NewEncoder(&b).Encode(s)
+	return b.Bytes(), err
+}
+
+func decodeSession(data []byte) (*Session, error) {
+	s := new(Session)
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func sessionExpired(s *Session, now time.Time) bool {
+	return !s.Expires.IsZero() && now.After(s.Expires)
+}
+
+// A CookieStore keeps sessions in the session cookie itself, encrypted like
+// a secure cookie so they can't be read or changed by the client.
+//
+// Sessions are limited to the size of a cookie, about 4KB.
+type CookieStore struct {
+	keys keyRing
+}
+
+// ErrSessionTooLarge is returned by CookieStore.Save for a session that
+// doesn't fit in a cookie.
+var ErrSessionTooLarge = errors.New("uweb: session too large for a cookie")
+
+// the name sessions are encrypted for, so other secure cookies can't be used
+// as sessions
+const cookieStoreName = "uweb session"
+
+// NewCookieStore creates a CookieStore. The first key is used to encrypt
+// sessions, and all of them to decrypt sessions, so keys can be rotated by
+// adding a new key to the front and removing old ones once the sessions they
+// encrypted have expired. Keys should be at least 32 random bytes.
+//
+// If no keys are given Config.CookieKeys are used.
+func NewCookieStore(keys ...[]byte) *CookieStore {
+	s := &CookieStore{}
+	if len(keys) > 0 {
+		s.keys = newKeyRing(keys)
+	}
+	return s
+}
+
+func (s *CookieStore) keyRing() keyRing {
+	if s.keys != nil {
+		return s.keys
+	}
+	return configKeyRing()
+}
+
+func (s *CookieStore) Load(value string) (*Session, error) {
+	data, err := s.keyRing().decode(cookieStoreName, value, 0)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSession(data)
+}
+
+func (s *CookieStore) Save(session *Session) (string, error) {
+	data, err := encodeSession(session)
+	if err != nil {
+		return "", err
+	}
+	value := s.keyRing().encode(cookieStoreName, data, true)
+	if len(value) > 4000 {
+		return "", ErrSessionTooLarge
+	}
+	return value, nil
+}
+
+// Delete does nothing, as the session is removed when its cookie is.
+func (s *CookieStore) Delete(id string) error {
+	return nil
+}
+
+// A MemoryStore keeps sessions in memory. They are lost when the program
+// exits, and aren't shared between processes.
+type MemoryStore struct {
+	lock      sync.Mutex
+	sessions  map[string][]byte
+	expires   map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions:  make(map[string][]byte),
+		expires:   make(map[string]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+func (s *MemoryStore) Load(id string) (*Session, error) {
+	s.lock.Lock()
+	data, ok := s.sessions[id]
+	s.lock.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	// sessions are stored encoded so each request gets its own copy
+	return decodeSession(data)
+}
+
+func (s *MemoryStore) Save(session *Session) (string, error) {
+	data, err := encodeSession(session)
+	if err != nil {
+		return "", err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sessions[session.ID] = data
+	s.expires[session.ID] = session.Expires
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) > sessionSweepInterval {
+		s.lastSweep = now
+		for id, expires := range s.expires {
+			if !expires.IsZero() && now.After(expires) {
+				delete(s.sessions, id)
+				delete(s.expires, id)
+			}
+		}
+	}
+	return session.ID, nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.sessions, id)
+	delete(s.expires, id)
+	return nil
+}
+
+// A FileStore keeps each session in a file in a directory.
+type FileStore struct {
+	dir string
+
+	lock      sync.Mutex
+	lastSweep time.Time
+}
+
+// NewFileStore creates a FileStore that keeps sessions in dir, which is
+// created if it doesn't exist.
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{dir: dir, lastSweep: time.Now()}
+}
+
+const sessionFilePrefix = "session_"
+
+// path returns the name of the file for a session, or "" if the ID couldn't
+// have been created by newSessionID.
+func (s *FileStore) path(id string) string {
+	if len(id) != 43 || strings.Trim(id, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_") != "" {
+		return ""
+	}
+	return filepath.Join(s.dir, sessionFilePrefix+id)
+}
+
+func (s *FileStore) Load(id string) (*Session, error) {
+	name := s.path(id)
+	if name == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeSession(data)
+}
+
+func (s *FileStore) Save(session *Session) (string, error) {
+	name := s.path(session.ID)
+	if name == "" {
+		return "", errors.New("uweb: invalid session ID")
+	}
+	data, err := encodeSession(session)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return "", err
+	}
+
+	// write to a temporary file first so a session is never half written
+	f, err := os.CreateTemp(s.dir, "tmp_")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	s.lock.Lock()
+	if time.Since(s.lastSweep) > sessionSweepInterval {
+		s.lastSweep = time.Now()
+		go s.sweep()
+	}
+	s.lock.Unlock()
+	return session.ID, nil
+}
+
+func (s *FileStore) Delete(id string) error {
+	name := s.path(id)
+	if name == "" {
+		return nil
+	}
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// sweep removes the files of expired sessions.
+func (s *FileStore) sweep() {
+	names, _ := filepath.Glob(filepath.Join(s.dir, sessionFilePrefix+"*"))
+	now := time.Now()
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			continue
+		}
+		if session, err := decodeSession(data); err == nil && sessionExpired(session, now) {
+			os.Remove(name)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An Event is a Server-Sent Event.
+//
+// Data may contain several lines. Retry, if set, tells the client how long
+// to wait before reconnecting.
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	Retry time.Duration
+}
+
+// format writes the event in the text/event-stream format.
+func (e *Event) format(b *bytes.Buffer) {
+	if e.ID != "" {
+		b.WriteString("id: " + stripNewlines(e.ID) + "\n")
+	}
+	if e.Event != "" {
+		b.WriteString("event: " + stripNewlines(e.Event) + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(int64(e.Retry/time.Millisecond), 10) + "\n")
+	}
+	// "\r\n", "\r" and "\n" all end a line in an event stream
+	data := strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(e.Data)
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+}
+
+func stripNewlines(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+/*
+An EventSource produces the events sent to a client by an event stream.
+
+Events is called once per request. lastEventID is the ID of the last event
+the client received before reconnecting, or "" for a new client, so the
+source can resume after it. The source closes the returned channel when there
+are no more events. done is closed when the client disconnects, after which
+the source should stop sending events.
+*/
+type EventSource interface {
+	Events(lastEventID string, done <-chan struct{}) <-chan Event
+}
+
+// channelSource is an EventSource for a channel of events.
+type channelSource <-chan Event
+
+func (c channelSource) Events(lastEventID string, done <-chan struct{}) <-chan Event {
+	return c
+}
+
+// LastEventID returns the ID of the last Server-Sent Event the client
+// received, which is sent when it reconnects to an event stream.
+func (c *Context) LastEventID() string {
+	if id := c.Request.Header.Get("Last-Event-ID"); id != "" {
+		return id
+	}
+	// sent by some polyfills that can't set headers
+	return c.Get.Get("lastEventId")
+}
+
+/*
+NewEventStream creates a response that sends the events from source to the
+client as Server-Sent Events.
+
+The response is kept open until source has no more events or the client
+disconnects. While it is open a comment is sent every Config.EventKeepAlive
+so that proxies don't close the connection.
+
+Targets can also return an EventSource, or a channel of Events, which is
+turned into an event stream automatically.
+
+	func Clock(ctx *uweb.Context) <-chan uweb.Event {
+		events := make(chan uweb.Event)
+		go func() {
+			defer close(events)
+			for t := range time.Tick(time.Second) {
+				select {
+				case events <- uweb.Event{Data: t.String()}:
+				case <-ctx.Request.Context().Done():
+					return
+				}
+			}
+		}()
+		return events
+	}
+*/
+func NewEventStream(ctx *Context, source EventSource) *Response {
+	resp := NewResponse()
+	setEventStream(resp, ctx, source)
+	return resp
+}
+
+// setEventStream sets the body and headers of resp to stream the events
+// from source.
+func setEventStream(resp *Response, ctx *Context, source EventSource) {
+	r, w := io.Pipe()
+	done := make(chan struct{})
+	events := source.Events(ctx.LastEventID(), done)
+
+	go func() {
+		defer close(done)
+		defer w.Close()
+
+		var keepAlive <-chan time.Time
+		if Config.EventKeepAlive > 0 {
+			ticker := time.NewTicker(Config.EventKeepAlive)
+			defer ticker.Stop()
+			keepAlive = ticker.C
+		}
+		disconnected := ctx.Request.Context().Done()
+
+		var b bytes.Buffer
+		for {
+			b.Reset()
+			select {
+			case e, ok := <-events:
+				if !ok {
+					return
+				}
+				e.format(&b)
+			case <-keepAlive:
+				b.WriteString(": keep-alive\n\n")
+			case <-disconnected:
+				return
+			}
+			// fails once the response is finished with and the reader is
+			// closed
+			if _, err := w.Write(b.Bytes()); err != nil {
+				return
+			}
+		}
+	}()
+
+	resp.Body = r
+	resp.Header().Set("Content-Type", "text/event-stream")
+	resp.Header().Set("Cache-Control", "no-cache")
+	resp.Header().Set("X-Accel-Buffering", "no")
+}
@@ -0,0 +1,157 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb_test
+
+import (
+	"bufio"
+	"github.com/calebbrown/uweb"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// counterSource sends numbered events forever, starting after the last
+// event ID, until the client disconnects.
+type counterSource struct {
+	finished chan bool
+}
+
+func (s *counterSource) Events(lastEventID string, done <-chan struct{}) <-chan uweb.Event {
+	events := make(chan uweb.Event)
+	start, _ := strconv.Atoi(lastEventID)
+	go func() {
+		defer close(events)
+		for i := start + 1; ; i++ {
+			select {
+			case events <- uweb.Event{ID: strconv.Itoa(i), Data: "count"}:
+			case <-done:
+				s.finished <- true
+				return
+			}
+		}
+	}()
+	return events
+}
+
+// idleSource never sends an event.
+type idleSource struct{}
+
+func (s idleSource) Events(lastEventID string, done <-chan struct{}) <-chan uweb.Event {
+	return make(chan uweb.Event)
+}
+
+func newEventServer(source uweb.EventSource) *httptest.Server {
+	a := uweb.NewApp()
+	a.Get("^events/$", func() <-chan uweb.Event {
+		events := make(chan uweb.Event, 2)
+		events <- uweb.Event{ID: "1", Event: "update", Data: "line 1\nline 2"}
+		events <- uweb.Event{Data: "last", Retry: 1500 * time.Millisecond}
+		close(events)
+		return events
+	})
+	a.Get("^lines/$", func() <-chan uweb.Event {
+		events := make(chan uweb.Event, 1)
+		events <- uweb.Event{Data: "a\r\nb\rc\nd\revent: admin\rid: 99"}
+		close(events)
+		return events
+	})
+	a.Get("^source/$", func() uweb.EventSource {
+		return source
+	})
+	return httptest.NewServer(a)
+}
+
+func TestEventStream(t *testing.T) {
+	server := newEventServer(nil)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Unexpected content type: %s", ct)
+	}
+	if cc := resp.Header.Get("Cache-Control"); cc != "no-cache" {
+		t.Errorf("Unexpected cache control: %s", cc)
+	}
+	expected := "id: 1\nevent: update\ndata: line 1\ndata: line 2\n\n" +
+		"retry: 1500\ndata: last\n\n"
+	if string(body) != expected {
+		t.Errorf("Unexpected body: %q", body)
+	}
+}
+
+func TestEventStreamLineEndings(t *testing.T) {
+	server := newEventServer(nil)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/lines/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	expected := "data: a\ndata: b\ndata: c\ndata: d\n" +
+		"data: event: admin\ndata: id: 99\n\n"
+	if string(body) != expected {
+		t.Errorf("Unexpected body: %q", body)
+	}
+}
+
+func TestEventStreamResume(t *testing.T) {
+	source := &counterSource{finished: make(chan bool, 1)}
+	server := newEventServer(source)
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"/source/", nil)
+	req.Header.Set("Last-Event-ID", "41")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+	if line != "id: 42\n" {
+		t.Errorf("Stream didn't resume after the last event: %q", line)
+	}
+
+	// disconnecting should stop the source
+	resp.Body.Close()
+	select {
+	case <-source.finished:
+	case <-time.After(5 * time.Second):
+		t.Error("Event source not stopped after the client disconnected")
+	}
+}
+
+func TestEventStreamKeepAlive(t *testing.T) {
+	uweb.Config.EventKeepAlive = 10 * time.Millisecond
+	defer func() {
+		uweb.Config.EventKeepAlive = 15 * time.Second
+	}()
+
+	server := newEventServer(idleSource{})
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/source/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+	if !strings.HasPrefix(line, ":") {
+		t.Errorf("Expected a keep-alive comment: %q", line)
+	}
+}
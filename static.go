@@ -0,0 +1,214 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StaticOptions holds the settings for a static file route.
+//
+// Index is the file served for a request for a directory. It defaults to
+// "index.html".
+//
+// ListDirectories serves a listing of a directory's contents when it has no
+// index file. By default a 404 is returned instead.
+//
+// MaxAge sets the max-age of the Cache-Control header sent with files. By
+// default no Cache-Control header is sent.
+//
+// The RouteOptions apply to the route as they do for RouteWithOptions.
+type StaticOptions struct {
+	RouteOptions
+	Index           string
+	ListDirectories bool
+	MaxAge          time.Duration
+}
+
+// staticFiles serves the files in a file system.
+type staticFiles struct {
+	root    fs.FS
+	options *StaticOptions
+
+	// ETags of files without a modification time, such as those in an
+	// embed.FS, which are hashed from their content
+	hashes sync.Map
+}
+
+// Serve the files in root from a url pattern. root is either the name of a
+// directory or an fs.FS, such as an embed.FS.
+//
+// The path left after the pattern is stripped names the file. Requests for
+// files outside root are not found.
+//
+//	app.Static("^static/", "./public")
+func (a *App) Static(pattern string, root interface{}) error {
+	return a.StaticWithOptions(pattern, root, nil)
+}
+
+// Serve the files in root from a url pattern, using the options for the
+// files and the route.
+func (a *App) StaticWithOptions(pattern string, root interface{}, options *StaticOptions) error {
+	if options == nil {
+		options = &StaticOptions{}
+	}
+	s := &staticFiles{options: options}
+	switch root := root.(type) {
+	case string:
+		s.root = os.DirFS(root)
+	case fs.FS:
+		s.root = root
+	default:
+		return fmt.Errorf("invalid static root %T", root)
+	}
+
+	handler := func(ctx *Context) *Response {
+		r, _ := a.router.GetRoute(pattern)
+		return s.serve(ctx, r.StripPattern(ctx.Path))
+	}
+
+	var routeOptions *RouteOptions
+	if !reflect.DeepEqual(options.RouteOptions, RouteOptions{}) {
+		routeOptions = &options.RouteOptions
+	}
+	return a.addRoute(pattern, "GET", handler, routeOptions)
+}
+
+// staticName converts a path from a url into the name of a file in an fs.FS.
+// ok is false if the path refers to a file outside the file system.
+func staticName(p string) (name string, ok bool) {
+	if strings.ContainsAny(p, "\\\x00") {
+		return "", false
+	}
+	name = strings.Trim(p, "/")
+	if name == "" {
+		return ".", true
+	}
+	return name, fs.ValidPath(name)
+}
+
+func abortFileError(err error) {
+	if os.IsPermission(err) {
+		Abort(403, "Forbidden")
+	}
+	Abort(404, "Not Found")
+}
+
+func (s *staticFiles) serve(ctx *Context, p string) *Response {
+	name, ok := staticName(p)
+	if !ok {
+		Abort(404, "Not Found")
+	}
+	f, err := s.root.Open(name)
+	if err != nil {
+		abortFileError(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		abortFileError(err)
+	}
+
+	if !info.IsDir() {
+		if strings.HasSuffix(p, "/") {
+			Abort(404, "Not Found")
+		}
+		return s.serveFile(ctx, name, f, info)
+	}
+
+	// relative links in a directory's index only work with a trailing slash
+	if !strings.HasSuffix(ctx.Request.URL.Path, "/") {
+		return NewRedirect(path.Base(ctx.Request.URL.Path)+"/", 301)
+	}
+	index := s.options.Index
+	if index == "" {
+		index = "index.html"
+	}
+	indexName := path.Join(name, index)
+	if f, err := s.root.Open(indexName); err == nil {
+		defer f.Close()
+		if info, err := f.Stat(); err == nil && !info.IsDir() {
+			return s.serveFile(ctx, indexName, f, info)
+		}
+	}
+	if s.options.ListDirectories {
+		return s.listDirectory(name)
+	}
+	Abort(404, "Not Found")
+	return nil
+}
+
+// serveFile sends the file's content, answering conditional and range
+// requests.
+func (s *staticFiles) serveFile(ctx *Context, name string, f fs.File, info fs.FileInfo) *Response {
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			abortFileError(err)
+		}
+		content = bytes.NewReader(b)
+	}
+
+	w := newInterceptWriter(ctx)
+	w.Header().Set("ETag", s.etag(name, info, content))
+	if s.options.MaxAge > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(s.options.MaxAge/time.Second)))
+	}
+	http.ServeContent(w, ctx.Request, info.Name(), info.ModTime(), content)
+	return w.Response()
+}
+
+func (s *staticFiles) etag(name string, info fs.FileInfo, content io.ReadSeeker) string {
+	if !info.ModTime().IsZero() {
+		return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+	}
+	if tag, ok := s.hashes.Load(name); ok {
+		return tag.(string)
+	}
+	h := sha1.New()
+	io.Copy(h, content)
+	content.Seek(0, io.SeekStart)
+	tag := fmt.Sprintf(`"%x"`, h.Sum(nil))
+	s.hashes.Store(name, tag)
+	return tag
+}
+
+// listDirectory creates a page linking to each of a directory's entries.
+func (s *staticFiles) listDirectory(name string) *Response {
+	entries, err := fs.ReadDir(s.root, name)
+	if err != nil {
+		abortFileError(err)
+	}
+	var b bytes.Buffer
+	b.WriteString("<!DOCTYPE html>\n<pre>\n")
+	for _, entry := range entries {
+		n := entry.Name()
+		if entry.IsDir() {
+			n += "/"
+		}
+		href := url.URL{Path: n}
+		fmt.Fprintf(&b, "<a href=\"%s\">%s</a>\n", html.EscapeString(href.String()), html.EscapeString(n))
+	}
+	b.WriteString("</pre>\n")
+
+	resp := NewResponse()
+	resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+	resp.Content = b.Bytes()
+	return resp
+}
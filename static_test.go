@@ -0,0 +1,155 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb_test
+
+import (
+	"github.com/calebbrown/uweb"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var staticModTime = time.Date(2013, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func init() {
+	os.Chtimes("testdata/static/public/style.css", staticModTime, staticModTime)
+
+	staticApp := uweb.NewApp()
+	app.Mount("^files/", staticApp)
+	staticApp.StaticWithOptions("^static/", "testdata/static/public", &uweb.StaticOptions{
+		MaxAge: time.Hour,
+	})
+	staticApp.StaticWithOptions("^embedded/", fstest.MapFS{
+		"data.json":     {Data: []byte(`{"a": 1}`)},
+		"docs/a b.txt":  {Data: []byte("a b")},
+		"docs/<b>.html": {Data: []byte("b")},
+	}, &uweb.StaticOptions{ListDirectories: true})
+}
+
+func TestStaticFile(t *testing.T) {
+	out := doSimpleRequest("GET", "/files/static/style.css", nil)
+	if out.Code != 200 || out.Body.String() != "body { color: red; }" {
+		t.Fatalf("Unexpected response: %d %s", out.Code, out.Body.String())
+	}
+	if ct := out.Header().Get("Content-Type"); ct != "text/css; charset=utf-8" {
+		t.Errorf("Unexpected content type: %s", ct)
+	}
+	if lm := out.Header().Get("Last-Modified"); lm != staticModTime.Format(http.TimeFormat) {
+		t.Errorf("Unexpected last modified: %s", lm)
+	}
+	if cc := out.Header().Get("Cache-Control"); cc != "public, max-age=3600" {
+		t.Errorf("Unexpected cache control: %s", cc)
+	}
+	etag := out.Header().Get("ETag")
+	if etag == "" {
+		t.Error("No ETag")
+	}
+
+	out = doSimpleRequest("HEAD", "/files/static/style.css", nil)
+	if out.Code != 200 || out.Body.Len() != 0 {
+		t.Errorf("Unexpected HEAD response: %d %s", out.Code, out.Body.String())
+	}
+
+	out = doSimpleRequest("GET", "/files/embedded/data.json", nil)
+	if out.Code != 200 || out.Body.String() != `{"a": 1}` {
+		t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+	}
+	if out.Header().Get("ETag") == "" {
+		t.Error("No ETag for a file without a modification time")
+	}
+}
+
+func TestStaticConditional(t *testing.T) {
+	etag := doSimpleRequest("GET", "/files/static/style.css", nil).Header().Get("ETag")
+
+	out := serve(app, "GET", "/files/static/style.css", nil, map[string]string{"If-None-Match": etag})
+	if out.Code != 304 || out.Body.Len() != 0 {
+		t.Errorf("If-None-Match: unexpected response %d %s", out.Code, out.Body.String())
+	}
+
+	out = serve(app, "GET", "/files/static/style.css", nil, map[string]string{
+		"If-Modified-Since": staticModTime.Add(time.Hour).Format(http.TimeFormat),
+	})
+	if out.Code != 304 {
+		t.Errorf("If-Modified-Since: unexpected response %d", out.Code)
+	}
+
+	out = serve(app, "GET", "/files/static/style.css", nil, map[string]string{
+		"If-Modified-Since": staticModTime.Add(-time.Hour).Format(http.TimeFormat),
+	})
+	if out.Code != 200 {
+		t.Errorf("Modified file: unexpected response %d", out.Code)
+	}
+
+	etag = doSimpleRequest("GET", "/files/embedded/data.json", nil).Header().Get("ETag")
+	out = serve(app, "GET", "/files/embedded/data.json", nil, map[string]string{"If-None-Match": etag})
+	if out.Code != 304 {
+		t.Errorf("Embedded If-None-Match: unexpected response %d", out.Code)
+	}
+}
+
+func TestStaticRange(t *testing.T) {
+	out := serve(app, "GET", "/files/static/style.css", nil, map[string]string{"Range": "bytes=0-3"})
+	if out.Code != 206 || out.Body.String() != "body" {
+		t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+	}
+	if cr := out.Header().Get("Content-Range"); cr != "bytes 0-3/20" {
+		t.Errorf("Unexpected content range: %s", cr)
+	}
+
+	out = serve(app, "GET", "/files/static/style.css", nil, map[string]string{"Range": "bytes=100-"})
+	if out.Code != 416 {
+		t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+	}
+	if cr := out.Header().Get("Content-Range"); cr != "bytes */20" {
+		t.Errorf("Unexpected content range: %s", cr)
+	}
+}
+
+func TestStaticDirectory(t *testing.T) {
+	out := doSimpleRequest("GET", "/files/static/css", nil)
+	if out.Code != 301 || out.Header().Get("Location") != "css/" {
+		t.Errorf("Unexpected response: %d %s", out.Code, out.Header().Get("Location"))
+	}
+
+	out = doSimpleRequest("GET", "/files/static/css/", nil)
+	if out.Code != 200 || out.Body.String() != "<p>css</p>" {
+		t.Errorf("Index not served: %d %s", out.Code, out.Body.String())
+	}
+
+	out = doSimpleRequest("GET", "/files/static/", nil)
+	if out.Code != 404 {
+		t.Errorf("Directory listed: %d %s", out.Code, out.Body.String())
+	}
+
+	out = doSimpleRequest("GET", "/files/embedded/docs/", nil)
+	body := out.Body.String()
+	if out.Code != 200 ||
+		!strings.Contains(body, `<a href="a%20b.txt">a b.txt</a>`) ||
+		!strings.Contains(body, `<a href="%3Cb%3E.html">&lt;b&gt;.html</a>`) {
+		t.Errorf("Unexpected listing: %d %s", out.Code, body)
+	}
+}
+
+func TestStaticNotFound(t *testing.T) {
+	paths := []string{
+		"/static/missing.css",
+		"/static/style.css/",
+		"/static/../secret.txt",
+		"/static/%2e%2e/secret.txt",
+		"/static/css/..%2f..%2fsecret.txt",
+		"/static/..%5csecret.txt",
+		"/embedded/../static/style.css",
+	}
+	for _, path := range paths {
+		out := doSimpleRequest("GET", "http://example.com/files"+path, nil)
+		if out.Code != 404 {
+			t.Errorf("%s: unexpected response %d %s", path, out.Code, out.Body.String())
+		}
+	}
+}
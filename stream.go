@@ -0,0 +1,106 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb
+
+import (
+	"io"
+	"net/http"
+)
+
+// NewStream creates a response that streams the content of r to the client.
+//
+// The content is sent as it is read, using chunked encoding unless a
+// Content-Length header is set, and flushed to the client after each read.
+// If r is an io.Closer it is closed once the response has been written.
+//
+//	func Export(ctx *uweb.Context) *uweb.Response {
+//	    f, err := os.Open("export.csv")
+//	    if err != nil {
+//	        uweb.Abort(404, "Not Found")
+//	    }
+//	    r := uweb.NewStream(f)
+//	    r.Header().Set("Content-Type", "text/csv")
+//	    return r
+//	}
+func NewStream(r io.Reader) *Response {
+	resp := NewResponse()
+	resp.Body = r
+	return resp
+}
+
+// copyStream copies from r to w, flushing w after each read if it is an
+// http.Flusher.
+func copyStream(w io.Writer, r io.Reader) (int64, error) {
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	var written int64
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			m, werr := w.Write(buf[:n])
+			written += int64(m)
+			if werr != nil {
+				return written, werr
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// streamWriter writes directly to the client, sending the headers of the
+// Context's Response before the first write.
+type streamWriter struct {
+	ctx *Context
+}
+
+func (w *streamWriter) Write(b []byte) (int, error) {
+	resp := w.ctx.Response
+	if w.ctx.Writer == nil {
+		resp.Content = append(resp.Content, b...)
+		return len(b), nil
+	}
+	if !resp.written {
+		w.ctx.runBeforeWrite()
+		resp.Header().Del("Content-Length")
+		resp.writeHeader(w.ctx.Writer)
+		resp.written = true
+	}
+	n, err := w.ctx.Writer.Write(b)
+	w.Flush()
+	return n, err
+}
+
+// Flush implements http.Flusher.
+func (w *streamWriter) Flush() {
+	if f, ok := w.ctx.Writer.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Stream returns a writer that sends what is written to it straight to the
+// client, flushing after each write.
+//
+// The status code, headers and cookies of the Context's Response are sent
+// before the first write, so they must be set before writing. Once something
+// has been written the target's return value is ignored.
+//
+//	func Export(ctx *uweb.Context) {
+//	    ctx.Response.Header().Set("Content-Type", "text/csv")
+//	    w := ctx.Stream()
+//	    for _, row := range rows {
+//	        fmt.Fprintf(w, "%s,%d\n", row.Name, row.Count)
+//	    }
+//	}
+func (c *Context) Stream() io.Writer {
+	return &streamWriter{ctx: c}
+}
@@ -0,0 +1,110 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb_test
+
+import (
+	"fmt"
+	"github.com/calebbrown/uweb"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// closeReader records whether it has been closed.
+type closeReader struct {
+	*strings.Reader
+	closed bool
+}
+
+func (r *closeReader) Close() error {
+	r.closed = true
+	return nil
+}
+
+// streamReader is the content of the stream/reader/ route.
+var streamReader *closeReader
+
+func init() {
+	streamApp := uweb.NewApp()
+	app.Mount("^stream/", streamApp)
+	streamApp.Get("^reader/$", func() *closeReader {
+		return streamReader
+	})
+	streamApp.Get("^writer/$", func(ctx *uweb.Context) string {
+		ctx.Response.Header().Set("Content-Type", "text/csv")
+		ctx.Response.SetCookie("streamed", "yes")
+		w := ctx.Stream()
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "row %d\n", i)
+		}
+		return "ignored"
+	})
+	streamApp.Get("^writer/panic/$", func(ctx *uweb.Context) {
+		fmt.Fprint(ctx.Stream(), "partial")
+		panic("failed part way through")
+	})
+}
+
+func TestStreamReader(t *testing.T) {
+	streamReader = &closeReader{Reader: strings.NewReader("streamed content")}
+
+	out := doSimpleRequest("GET", "/stream/reader/", nil)
+	if out.Body.String() != "streamed content" {
+		t.Errorf("Unexpected body: %s", out.Body.String())
+	}
+	if out.Header().Get("Content-Length") != "" {
+		t.Error("Streamed response has a Content-Length")
+	}
+	if !out.Flushed {
+		t.Error("Streamed response was not flushed")
+	}
+	if !streamReader.closed {
+		t.Error("Reader was not closed")
+	}
+}
+
+func TestStreamWriter(t *testing.T) {
+	out := doSimpleRequest("GET", "/stream/writer/", nil)
+	if out.Body.String() != "row 0\nrow 1\nrow 2\n" {
+		t.Errorf("Unexpected body: %s", out.Body.String())
+	}
+	if out.Header().Get("Content-Type") != "text/csv" {
+		t.Errorf("Unexpected content type: %s", out.Header().Get("Content-Type"))
+	}
+	if !strings.HasPrefix(out.Header().Get("Set-Cookie"), "streamed=yes") {
+		t.Error("Cookie not sent with the streamed response")
+	}
+	if !out.Flushed {
+		t.Error("Streamed response was not flushed")
+	}
+
+	out = doSimpleRequest("GET", "/stream/writer/panic/", nil)
+	if out.Code != 200 || out.Body.String() != "partial" {
+		t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+	}
+}
+
+func TestStreamChunked(t *testing.T) {
+	streamReader = &closeReader{Reader: strings.NewReader(strings.Repeat("x", 100000))}
+	server := httptest.NewServer(app)
+	defer server.Close()
+
+	for _, path := range []string{"/stream/reader/", "/stream/writer/"} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if len(resp.TransferEncoding) != 1 || resp.TransferEncoding[0] != "chunked" {
+			t.Errorf("%s: unexpected transfer encoding %v", path, resp.TransferEncoding)
+		}
+		if len(body) == 0 {
+			t.Errorf("%s: empty body", path)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TemplateOptions holds the settings for loading templates.
+//
+// Layouts is the directory, relative to the root, holding the layouts and
+// partial templates shared by every page. It defaults to "layouts".
+//
+// Extensions lists the extensions of the files that are loaded. It defaults
+// to ".html".
+//
+// Funcs are added to the templates along with the built in funcs.
+type TemplateOptions struct {
+	Layouts    string
+	Extensions []string
+	Funcs      template.FuncMap
+}
+
+// A TemplateResult is returned by a Target to render one of the App's
+// templates. See App.LoadTemplates.
+type TemplateResult struct {
+	Name string
+	Data interface{}
+}
+
+// Template creates a TemplateResult that renders the template called name
+// with data.
+//
+//	func UserView(ctx *uweb.Context, id int) uweb.TemplateResult {
+//		return uweb.Template("users/show.html", findUser(id))
+//	}
+func Template(name string, data interface{}) TemplateResult {
+	return TemplateResult{Name: name, Data: data}
+}
+
+// templateSet holds the pages loaded from a file system.
+type templateSet struct {
+	root    fs.FS
+	options TemplateOptions
+
+	lock    sync.RWMutex
+	pages   map[string]*template.Template
+	modTime map[string]time.Time
+}
+
+/*
+LoadTemplates loads the html/template files in root, which is either the name
+of a directory or an fs.FS, such as an embed.FS. It replaces any templates
+loaded before.
+
+Each file outside the layouts directory is a page, named by its path from
+root, such as "users/show.html". A page is parsed along with every file in
+the layouts directory, so it can use the templates they define and fill in
+their blocks:
+
+	layouts/base.html:
+	{{define "base"}}<html><body>{{block "content" .}}{{end}}</body></html>{{end}}
+
+	index.html:
+	{{template "base" .}}
+	{{define "content"}}<a href="{{url "profile" .User.ID}}">Profile</a>{{end}}
+
+The url func builds the path to a named route, like App.URL, from the App
+serving the request, so it works from inside mounted Apps. The csrfToken and
+csrfField funcs call Context.CSRFToken and Context.CSRFField.
+
+When Config.Debug is set the files are checked when a template is rendered,
+and loaded again if any of them changed.
+*/
+func (a *App) LoadTemplates(root interface{}) error {
+	return a.LoadTemplatesWithOptions(root, nil)
+}
+
+// LoadTemplatesWithOptions loads the templates in root, like LoadTemplates,
+// using the options.
+func (a *App) LoadTemplatesWithOptions(root interface{}, options *TemplateOptions) error {
+	s := &templateSet{}
+	if options != nil {
+		s.options = *options
+	}
+	if s.options.Layouts == "" {
+		s.options.Layouts = "layouts"
+	}
+	if len(s.options.Extensions) == 0 {
+		s.options.Extensions = []string{".html"}
+	}
+	switch root := root.(type) {
+	case string:
+		s.root = os.DirFS(root)
+	case fs.FS:
+		s.root = root
+	default:
+		return fmt.Errorf("invalid template root %T", root)
+	}
+
+	if err := s.load(); err != nil {
+		return err
+	}
+	a.templates = s
+	return nil
+}
+
+// files finds the template files and when they were modified.
+func (s *templateSet) files() (map[string]time.Time, error) {
+	files := make(map[string]time.Time)
+	err := fs.WalkDir(s.root, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		for _, ext := range s.options.Extensions {
+			if strings.HasSuffix(name, ext) {
+				info, err := d.Info()
+				if err != nil {
+					return err
+				}
+				files[name] = info.ModTime()
+				break
+			}
+		}
+		return nil
+	})
+	return files, err
+}
+
+// load parses every page along with the layouts.
+func (s *templateSet) load() error {
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+
+	funcs := template.FuncMap{
+		// replaced by the request's funcs when rendered
+		"url": func(name string, args ...interface{}) (string, error) {
+			return "", nil
+		},
+		"csrfToken": func() string { return "" },
+		"csrfField": func() template.HTML { return "" },
+	}
+	for name, f := range s.options.Funcs {
+		funcs[name] = f
+	}
+	layouts := template.New("").Funcs(funcs)
+	prefix := s.options.Layouts + "/"
+	for name := range files {
+		if strings.HasPrefix(name, prefix) {
+			if err := parseTemplateFile(layouts, s.root, name); err != nil {
+				return err
+			}
+		}
+	}
+
+	pages := make(map[string]*template.Template)
+	for name := range files {
+		if strings.HasPrefix(name, prefix) {
+			continue
+		}
+		page, err := layouts.Clone()
+		if err == nil {
+			err = parseTemplateFile(page, s.root, name)
+		}
+		if err != nil {
+			return err
+		}
+		pages[name] = page
+	}
+
+	s.lock.Lock()
+	s.pages = pages
+	s.modTime = files
+	s.lock.Unlock()
+	return nil
+}
+
+func parseTemplateFile(t *template.Template, root fs.FS, name string) error {
+	b, err := fs.ReadFile(root, name)
+	if err != nil {
+		return err
+	}
+	_, err = t.New(name).Parse(string(b))
+	return err
+}
+
+// reloadIfChanged loads the templates again if any of the files have been
+// modified, added or removed.
+func (s *templateSet) reloadIfChanged() error {
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	s.lock.RLock()
+	changed := len(files) != len(s.modTime)
+	for name, modTime := range files {
+		if old, ok := s.modTime[name]; !ok || !old.Equal(modTime) {
+			changed = true
+		}
+	}
+	s.lock.RUnlock()
+	if changed {
+		debugf("Reloading templates")
+		return s.load()
+	}
+	return nil
+}
+
+// render executes a page, with the funcs bound to the request.
+func (s *templateSet) render(funcs template.FuncMap, name string, data interface{}) ([]byte, error) {
+	if Config.Debug {
+		if err := s.reloadIfChanged(); err != nil {
+			return nil, err
+		}
+	}
+	s.lock.RLock()
+	page, ok := s.pages[name]
+	s.lock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("template '%s' not found", name)
+	}
+
+	// pages are cloned so the funcs can be bound to the request
+	t, err := page.Clone()
+	if err != nil {
+		return nil, err
+	}
+	t.Funcs(funcs)
+
+	var b bytes.Buffer
+	if err := t.ExecuteTemplate(&b, name, data); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// renderTemplate renders one of the App's templates into the Context's
+// Response.
+func (a *App) renderTemplate(ctx *Context, result TemplateResult) {
+	if a.templates == nil {
+		panic("No templates loaded for the App")
+	}
+	app := ctx.app
+	if app == nil {
+		app = a
+	}
+	funcs := template.FuncMap{
+		"url":       app.URL,
+		"csrfToken": ctx.CSRFToken,
+		"csrfField": ctx.CSRFField,
+	}
+	content, err := a.templates.render(funcs, result.Name, result.Data)
+	if err != nil {
+		panic(err)
+	}
+	ctx.Response.Content = content
+}
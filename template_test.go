@@ -0,0 +1,137 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb_test
+
+import (
+	"github.com/calebbrown/uweb"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var templateFiles = map[string]string{
+	"layouts/base.html": `{{define "base"}}<title>{{block "title" .}}Site{{end}}</title>` +
+		`<main>{{block "content" .}}{{end}}</main>{{template "footer"}}{{end}}`,
+	"layouts/footer.html": `{{define "footer"}}<footer>{{shout "footer"}}</footer>{{end}}`,
+	"index.html":          `{{template "base" .}}{{define "content"}}Hello {{.}}{{end}}`,
+	"users/show.html": `{{template "base" .}}{{define "title"}}User{{end}}` +
+		`{{define "content"}}<a href="{{url "user" .}}">{{.}}</a>{{end}}`,
+	"notes.txt": "not a template",
+}
+
+func writeTemplates(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "uweb-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for name, content := range templateFiles {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	}
+	return dir
+}
+
+func TestTemplates(t *testing.T) {
+	fsys := fstest.MapFS{}
+	for name, content := range templateFiles {
+		fsys[name] = &fstest.MapFile{Data: []byte(content)}
+	}
+
+	for _, root := range []interface{}{writeTemplates(t), fsys} {
+		a := uweb.NewApp()
+		err := a.LoadTemplatesWithOptions(root, &uweb.TemplateOptions{
+			Funcs: template.FuncMap{"shout": strings.ToUpper},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.Get("^$", func() uweb.TemplateResult {
+			return uweb.Template("index.html", "<world>")
+		})
+		a.Get("^missing/$", func() uweb.TemplateResult {
+			return uweb.Template("missing.html", nil)
+		})
+
+		// the url func reverses routes from the App serving the request
+		users := uweb.NewApp()
+		users.LoadTemplatesWithOptions(root, &uweb.TemplateOptions{
+			Funcs: template.FuncMap{"shout": strings.ToLower},
+		})
+		users.RouteWithOptions("^([a-z]+)/$", "GET", func(name string) uweb.TemplateResult {
+			return uweb.Template("users/show.html", name)
+		}, &uweb.RouteOptions{Name: "user"})
+		a.Mount("^users/", users)
+
+		out := serve(a, "GET", "/", nil, nil)
+		expected := "<title>Site</title><main>Hello &lt;world&gt;</main><footer>FOOTER</footer>"
+		if out.Code != 200 || out.Body.String() != expected {
+			t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+		}
+		if ct := out.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+			t.Errorf("Unexpected content type: %s", ct)
+		}
+
+		out = serve(a, "GET", "/users/bob/", nil, nil)
+		expected = `<title>User</title><main><a href="/users/bob/">bob</a></main><footer>footer</footer>`
+		if out.Code != 200 || out.Body.String() != expected {
+			t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+		}
+
+		out = serve(a, "GET", "/missing/", nil, nil)
+		if out.Code != 500 {
+			t.Errorf("Missing template: unexpected response %d", out.Code)
+		}
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	a := uweb.NewApp()
+	err := a.LoadTemplates(fstest.MapFS{"index.html": {Data: []byte("{{.Broken")}})
+	if err == nil {
+		t.Error("Invalid template loaded")
+	}
+	if err := a.LoadTemplates(42); err == nil {
+		t.Error("Invalid root accepted")
+	}
+
+	a.Get("^$", func() uweb.TemplateResult { return uweb.Template("index.html", nil) })
+	if out := serve(a, "GET", "/", nil, nil); out.Code != 500 {
+		t.Errorf("Unexpected response without templates: %d", out.Code)
+	}
+}
+
+func TestTemplateReload(t *testing.T) {
+	dir := writeTemplates(t)
+	a := uweb.NewApp()
+	a.LoadTemplatesWithOptions(dir, &uweb.TemplateOptions{
+		Funcs: template.FuncMap{"shout": strings.ToUpper},
+	})
+	a.Get("^$", func() uweb.TemplateResult {
+		return uweb.Template("index.html", "world")
+	})
+
+	index := filepath.Join(dir, "index.html")
+	ioutil.WriteFile(index, []byte("changed"), 0644)
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(index, later, later)
+
+	if out := serve(a, "GET", "/", nil, nil); strings.Contains(out.Body.String(), "changed") {
+		t.Error("Templates reloaded outside of Debug mode")
+	}
+
+	uweb.Config.Debug = true
+	defer func() {
+		uweb.Config.Debug = false
+	}()
+	if out := serve(a, "GET", "/", nil, nil); out.Body.String() != "changed" {
+		t.Errorf("Templates not reloaded in Debug mode: %s", out.Body.String())
+	}
+}
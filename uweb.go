@@ -5,21 +5,24 @@
 package uweb
 
 import (
-	"bytes"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"html"
 	"io"
 	go_log "log"
+	"mime/multipart"
 	"net"
 	"net/http"
 	"net/http/fcgi"
 	"net/url"
 	"reflect"
 	"regexp"
+	"regexp/syntax"
 	go_debug "runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 //////////////////////////////////////////////////////////////////////////////
@@ -30,11 +33,6 @@ func argIsContext(argType reflect.Type) bool {
 		argType.Elem() == reflect.TypeOf(Context{})
 }
 
-func argIsStringSlice(argType reflect.Type) bool {
-	return argType.Kind() == reflect.Slice &&
-		argType.Elem().Kind() == reflect.String
-}
-
 func runServer(host string, server func(net.Listener) error) error {
 	doAutoReload()
 	log("Listening on " + host)
@@ -57,12 +55,20 @@ type responseWriter interface {
 }
 
 // Response represents a http response to a received request
+//
+// If Body is set it is streamed to the client in place of Content. See
+// NewStream.
 type Response struct {
 	header       http.Header
 	Code         int
 	Content      []byte
+	Body         io.Reader
 	WriteContent bool
 	Cookies      map[string]*http.Cookie
+
+	// written is set when the response has already been written directly to
+	// the http.ResponseWriter.
+	written bool
 }
 
 func NewResponse() *Response {
@@ -101,11 +107,19 @@ func (r *Response) StatusCode() int {
 	return r.Code
 }
 
+// SetCookieWithOptions sets a cookie using the options instead of
+// Config.CookieOptions. If options is nil Config.CookieOptions are used.
 func (r *Response) SetCookieWithOptions(name, value string, options *CookieOptions) {
+	if options == nil {
+		options = Config.CookieOptions
+	}
 	r.Cookies[name] = options.Cookie(name, value)
 }
 
 func (r *Response) DeleteCookieWithOptions(name string, options *CookieOptions) {
+	if options == nil {
+		options = Config.CookieOptions
+	}
 	r.Cookies[name] = options.DestroyCookie(name)
 }
 
@@ -117,12 +131,47 @@ func (r *Response) DeleteCookie(name string) {
 	r.DeleteCookieWithOptions(name, Config.CookieOptions)
 }
 
+// keepCookie copies a cookie set on the Context's Response by middleware to
+// resp, if the target returned a different Response.
+func keepCookie(ctx *Context, resp *Response, name string) {
+	if resp == nil || resp == ctx.Response {
+		return
+	}
+	if _, ok := resp.Cookies[name]; !ok && ctx.Response.Cookies[name] != nil {
+		resp.Cookies[name] = ctx.Response.Cookies[name]
+	}
+}
+
 func (r *Response) WriteResponse(w http.ResponseWriter) {
-	// Only set the content length if it hasn't already been set
-	if r.Header().Get("Content-Length") == "" {
+	if r.written {
+		return
+	}
+
+	if r.Body != nil {
+		if c, ok := r.Body.(io.Closer); ok {
+			defer c.Close()
+		}
+	} else if r.Header().Get("Content-Length") == "" && r.Code != 204 && r.Code != 304 {
+		// Only set the content length if it hasn't already been set, and
+		// the response can have content
 		r.Header().Set("Content-Length", strconv.Itoa(len(r.Content)))
 	}
 
+	r.writeHeader(w)
+
+	// write the content
+	if !r.WriteContent {
+		return
+	}
+	if r.Body != nil {
+		copyStream(w, r.Body)
+	} else {
+		w.Write(r.Content)
+	}
+}
+
+// writeHeader writes the status code, headers and cookies.
+func (r *Response) writeHeader(w http.ResponseWriter) {
 	// set the headers
 	for k, values := range r.header {
 		for _, v := range values {
@@ -137,22 +186,33 @@ func (r *Response) WriteResponse(w http.ResponseWriter) {
 
 	// write the headers
 	w.WriteHeader(r.Code)
-
-	// write the content
-	if r.WriteContent {
-		w.Write(r.Content)
-	}
 }
 
+// Merge copies the status code and headers of resp into the Response.
 func (r *Response) Merge(resp *Response) {
 	r.Code = resp.Code
-	// TODO: Headers
+	for k, values := range resp.header {
+		r.header[k] = values
+	}
 }
 
+// An ErrorResponse is a response for an error. Fields lists what was wrong
+// with the fields of a request that was rejected, if anything.
 type ErrorResponse struct {
 	Response
 	Stack   string
 	Message string
+	Fields  []FieldError
+}
+
+// A FieldError describes why the value sent for one field of a request was
+// rejected. Field is the name the value was sent with, which is empty if the
+// error is about the request as a whole. Rule is the validation rule the
+// value failed, if any, see Validate.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule,omitempty"`
+	Message string `json:"message"`
 }
 
 func NewError(code int, message string) *ErrorResponse {
@@ -165,6 +225,30 @@ func NewError(code int, message string) *ErrorResponse {
 	return r
 }
 
+// Error returns the message so an ErrorResponse can be used as an error.
+func (e *ErrorResponse) Error() string {
+	return e.Message
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// errorResponse converts an error returned by a target into an
+// ErrorResponse. Errors with a StatusCode() int method use that status code,
+// others are a 500. The error's message is only shown to the client for 4xx
+// errors.
+func errorResponse(err error) *ErrorResponse {
+	var e *ErrorResponse
+	if errors.As(err, &e) {
+		return e
+	}
+	code := errorCode(err, 500)
+	e = NewError(code, err.Error())
+	if code >= 500 {
+		e.Content = []byte(http.StatusText(code))
+	}
+	return e
+}
+
 func (e *ErrorResponse) SetStack(clean bool) {
 	s := string(go_debug.Stack())
 
@@ -187,12 +271,32 @@ func (e *ErrorResponse) SetStack(clean bool) {
 //////////////////////////////////////////////////////////////////////////////
 // Cookie Config
 
+// CookieOptions holds the attributes of the cookies set by a Response.
+//
+// SameSite is one of http.SameSiteLaxMode, http.SameSiteStrictMode or
+// http.SameSiteNoneMode, or zero to leave the attribute out. Cookies with
+// SameSite set to None are always Secure, as browsers reject them otherwise.
+//
+// Expires is an absolute expiry time, for clients that don't support MaxAge.
+//
+// Cookies whose names start with "__Secure-" must be Secure, and those whose
+// names start with "__Host-" must also have a Path of "/" and no Domain.
+// Creating a cookie that breaks these rules panics.
+//
+// The options for a single cookie can be changed by copying
+// Config.CookieOptions:
+//
+//    options := *uweb.Config.CookieOptions
+//    options.SameSite = http.SameSiteStrictMode
+//    ctx.Response.SetCookieWithOptions("__Host-prefs", prefs, &options)
 type CookieOptions struct {
 	Path     string
 	Domain   string
 	MaxAge   int
+	Expires  time.Time
 	Secure   bool
 	HttpOnly bool
+	SameSite http.SameSite
 }
 
 func NewCookieOptions() *CookieOptions {
@@ -206,37 +310,83 @@ func NewCookieOptions() *CookieOptions {
 
 // Creates a cookie with the parameters defined by the CookieOptions
 func (cc *CookieOptions) Cookie(name, value string) *http.Cookie {
-	return &http.Cookie{
+	c := &http.Cookie{
 		Name:     name,
 		Value:    value,
 		Path:     cc.Path,
 		Domain:   cc.Domain,
 		MaxAge:   cc.MaxAge,
+		Expires:  cc.Expires,
 		Secure:   cc.Secure,
 		HttpOnly: cc.HttpOnly,
+		SameSite: cc.SameSite,
+	}
+	if c.SameSite == http.SameSiteNoneMode {
+		c.Secure = true
+	}
+	if err := checkCookiePrefix(c); err != nil {
+		panic(err)
 	}
+	return c
 }
 
 // Creates a cookie that will destroy a cookie stored in the user-agent
 func (cc *CookieOptions) DestroyCookie(name string) *http.Cookie {
 	c := cc.Cookie(name, "")
 	c.MaxAge = -9999
+	// for clients that don't support Max-Age
+	c.Expires = time.Unix(0, 0)
 	return c
 }
 
+// checkCookiePrefix checks the rules for cookies with the "__Secure-" and
+// "__Host-" prefixes.
+func checkCookiePrefix(c *http.Cookie) error {
+	host := strings.HasPrefix(c.Name, "__Host-")
+	if (host || strings.HasPrefix(c.Name, "__Secure-")) && !c.Secure {
+		return fmt.Errorf("cookie '%s' must be Secure", c.Name)
+	}
+	if host && (c.Path != "/" || c.Domain != "") {
+		return fmt.Errorf("cookie '%s' must have a Path of \"/\" and no Domain", c.Name)
+	}
+	return nil
+}
+
 //////////////////////////////////////////////////////////////////////////////
 // Context
 
 // Context wraps up all the data related to the request and makes it easier to
 // access it.
+//
+// Writer is the http.ResponseWriter for the request. It is nil if the
+// Context wasn't created by ServeHTTP. Writing to it directly bypasses
+// Response.
+//
+// Args holds the values captured by the url pattern of the matched route.
+// Params holds the values of the named capture groups, including those of
+// the patterns the route's App is mounted at.
 type Context struct {
 	Request  *http.Request
 	Response *Response
+	Writer   http.ResponseWriter
 	Get      url.Values
 	Method   string
 	Path     string
 	Cookies  []*http.Cookie
-	//Args []string
+	Args     []string
+	Params   map[string]string
+
+	argNames      []string
+	argMatched    []bool
+	webSocket     *WebSocketConn
+	multipartForm *multipart.Form
+	body          []byte
+	api           bool
+	app           *App
+	sessions      *sessionManager
+	csrf          *csrfState
+	cors          *corsPolicy
+	beforeWrite   []func()
 }
 
 // Create a new instance of Context
@@ -248,6 +398,25 @@ func NewContext(r *http.Request) *Context {
 		Path:     r.URL.Path,
 		Method:   r.Method,
 		Cookies:  r.Cookies(),
+		Params:   make(map[string]string),
+	}
+}
+
+// OnBeforeWrite registers f to be called just before the headers of the
+// Context's Response are sent, for responses written while the target runs:
+// those written with Stream, files served by App.Static and the output of
+// handlers adapted with HTTPHandler. Headers f sets on c.Response are sent.
+// See Middleware.
+func (c *Context) OnBeforeWrite(f func()) {
+	c.beforeWrite = append(c.beforeWrite, f)
+}
+
+// runBeforeWrite calls the functions registered with OnBeforeWrite, once.
+func (c *Context) runBeforeWrite() {
+	funcs := c.beforeWrite
+	c.beforeWrite = nil
+	for _, f := range funcs {
+		f()
 	}
 }
 
@@ -288,6 +457,51 @@ url pattern and return a rendered string:
 
 	uweb.Get("^([0-9]+)/([a-z-]+)/", MyTarget)
 
+Arguments after the Context don't have to be strings. Captures are converted
+to bool, int, uint and float arguments (of any size) and to time.Time dates.
+Converters for other types are added with RegisterArgParser. If a capture
+can't be converted the request is aborted with a 404:
+
+	func BlogEdit(ctx *uweb.Context, id int, slug string) string {
+		...
+	}
+
+A struct argument is filled in from the pattern's named capture groups. Each
+field is set from the group named by its "path" tag, or by its name. When a
+target has a struct argument the other arguments only take the unnamed
+captures, so groups can be added to the pattern without changing the target:
+
+	type PostArgs struct {
+		ID   int    `path:"id"`
+		Slug string `path:"slug"`
+	}
+
+	func PostView(ctx *uweb.Context, args PostArgs) string {
+		...
+	}
+
+	uweb.Get("^users/(?P<id>[0-9]+)/posts/(?P<slug>[a-z-]+)$", PostView)
+
+A struct argument is also filled in from the request. A JSON body is decoded
+into it, fields tagged with "form" are set from a url-encoded or multipart
+body, and fields tagged with "query" from the query string. Fields without
+a tag are set from a form body if there is one, or from the query string if
+the body isn't JSON. Fields tagged with `json:"-"` are only set if they also
+have a "form" or "query" tag. If the values can't be converted the request
+is aborted with a 400
+error that lists the fields. The argument is then checked by the rules in
+its fields' "validate" tags, and the request aborted with a 422 error listing
+the invalid fields if it fails, see Validate:
+
+	type CreateUser struct {
+		Name  string `json:"name" form:"name" validate:"required"`
+		Email string `json:"email" form:"email" validate:"required,email"`
+		Admin bool   `json:"-"`
+	}
+
+	func CreateUserView(ctx *uweb.Context, in CreateUser) CreateUserResult {
+		...
+	}
 
 Additionally a target can be a variadic function, which is useful if
 the target is called with an varing number of arguments:
@@ -297,10 +511,19 @@ the target is called with an varing number of arguments:
 	}
 
 The return value can be one of a variety of types: string, []byte, *Response,
-and io.Reader are all supported.
+and io.Reader are all supported. An io.Reader is streamed to the client
+rather than read into memory, and closed afterwards if it is an io.Closer.
+An EventSource or a channel of Events is sent as Server-Sent Events, see
+NewEventStream.
 
-Finally, a target can return a value of any type that can be successfully
-converted into JSON using json.Marshal.
+A target can also write its response progressively to the writer returned
+by Context.Stream, or render one of the App's templates by returning the
+result of Template, see App.LoadTemplates.
+
+Finally, a target can return a value of any other type, which is encoded
+for the client by the Encoder chosen by the request's Accept header. By
+default values are converted into JSON using json.Marshal, see
+RegisterEncoder for the others.
 
 	type MyStruct struct {
 		Name string
@@ -310,6 +533,20 @@ converted into JSON using json.Marshal.
 		return MyStruct{Name: "Joe Blogs"}
 	}
 
+Any of these can be followed by an error, or a target can return just an
+error. When the error isn't nil the value is ignored and the error is
+rendered by the App's error handlers, as if it had been raised with Abort.
+An *ErrorResponse is used as is. Errors with a StatusCode() int method, such
+as those returned by Validate, get that status code and others are a 500:
+
+	func UserView(ctx *uweb.Context, id int) (*User, error) {
+		user, err := db.FindUser(id)
+		if err == sql.ErrNoRows {
+			return nil, uweb.NewError(404, "User Not Found")
+		}
+		return user, err
+	}
+
 */
 type Target interface{}
 
@@ -334,19 +571,32 @@ Error handlers can optionally accept a Context or the originating ErrorResponse
 Like Targets the return value for error handlers can be one of a variety of
 types: string, []byte, *Response, and io.Reader are all supported.
 
-Finally, error handlers can return a value of any type that can be successfully
-converted into JSON using json.Marhsal.
+Finally, error handlers can return a value of any other type, which is encoded
+for the client in the same way as a Target's.
 */
 type ErrorHandler interface{}
 
 type wrappedTarget func(ctx *Context, args ...string) []reflect.Value
 type wrappedErrorHandler func(ctx *Context, e *ErrorResponse) []reflect.Value
 
+// targetArg describes one of a target's arguments after the Context.
+//
+// Arguments are either filled in from the url pattern captures in order or,
+// when bind is set, bound from the request by bindRequest.
+type targetArg struct {
+	argType reflect.Type
+	bind    bool
+}
+
 func wrapTarget(target Target) wrappedTarget {
 	function := reflect.ValueOf(target)
 	funcType := function.Type()
 	hasContext := false
-	hasArgs := false
+	hasWebSocket := false
+	hasPositional := false
+	hasBind := false
+	var targetArgs []targetArg
+	var variadicType reflect.Type
 
 	if inNum := funcType.NumIn(); inNum > 0 {
 		firstArg := 0
@@ -354,17 +604,29 @@ func wrapTarget(target Target) wrappedTarget {
 			hasContext = true
 			firstArg = 1
 		}
-		hasArgs = inNum > firstArg
-		if hasArgs {
-			valid := true
-			for i := firstArg; i < inNum; i++ {
-				if funcType.In(i).Kind() != reflect.String {
-					valid = false
-					break
+		if inNum > firstArg && argIsWebSocket(funcType.In(firstArg)) {
+			hasWebSocket = true
+			firstArg++
+		}
+		for i := firstArg; i < inNum; i++ {
+			argType := funcType.In(i)
+			if funcType.IsVariadic() && i == inNum-1 {
+				argType = argType.Elem()
+				if !argTypeSupported(argType) {
+					panic(fmt.Sprintf("Invalid target function '%s'. Incorrect argument types.", function.String()))
 				}
-			}
-
-			if !(valid || argIsStringSlice(funcType.In(firstArg))) {
+				variadicType = argType
+				hasPositional = true
+			} else if argTypeSupported(argType) {
+				targetArgs = append(targetArgs, targetArg{argType: argType})
+				hasPositional = true
+			} else if argIsBindable(argType) {
+				if err := checkValidateTags(argType); err != nil {
+					panic(fmt.Sprintf("Invalid target function '%s'. %s.", function.String(), err))
+				}
+				targetArgs = append(targetArgs, targetArg{argType: argType, bind: true})
+				hasBind = true
+			} else {
 				panic(fmt.Sprintf("Invalid target function '%s'. Incorrect argument types.", function.String()))
 			}
 		}
@@ -377,10 +639,57 @@ func wrapTarget(target Target) wrappedTarget {
 			callArgs = append(callArgs, reflect.ValueOf(ctx))
 		}
 
-		if hasArgs {
-			for _, arg := range args {
-				callArgs = append(callArgs, reflect.ValueOf(arg))
+		if hasWebSocket {
+			callArgs = append(callArgs, reflect.ValueOf(ctx.webSocket))
+		}
+
+		// captures that didn't take part in the match are passed as zero
+		// values
+		matched := ctx.argMatched
+		if len(matched) != len(args) {
+			matched = nil
+		}
+
+		// when named groups are bound to a struct the other arguments only
+		// take the unnamed captures
+		if hasBind && len(ctx.argNames) == len(args) {
+			var unnamed []string
+			var unnamedMatched []bool
+			for i, arg := range args {
+				if ctx.argNames[i] == "" {
+					unnamed = append(unnamed, arg)
+					unnamedMatched = append(unnamedMatched, matched == nil || matched[i])
+				}
+			}
+			args, matched = unnamed, unnamedMatched
+		}
+
+		argValue := func(t reflect.Type, i int) reflect.Value {
+			if matched != nil && !matched[i] {
+				return reflect.Zero(t)
+			}
+			return mustParseArg(t, args[i])
+		}
+
+		n := 0
+		for _, arg := range targetArgs {
+			if arg.bind {
+				callArgs = append(callArgs, bindRequest(ctx, arg.argType))
+				continue
+			}
+			if n == len(args) {
+				panic("Too few arguments for target")
+			}
+			callArgs = append(callArgs, argValue(arg.argType, n))
+			n++
+		}
+
+		if variadicType != nil {
+			for ; n < len(args); n++ {
+				callArgs = append(callArgs, argValue(variadicType, n))
 			}
+		} else if n < len(args) && hasPositional {
+			panic("Too many arguments for target")
 		}
 
 		return function.Call(callArgs)
@@ -428,7 +737,12 @@ func wrapErrorHandler(handler ErrorHandler) wrappedErrorHandler {
 	return wrapped
 }
 
+// defaultErrorHandler renders an ErrorResponse as an HTML page, or as
+// application/problem+json for API routes and clients that ask for JSON.
 func defaultErrorHandler(ctx *Context, e *ErrorResponse) []reflect.Value {
+	if wantsProblem(ctx) {
+		return problemErrorHandler(ctx, e)
+	}
 	s := `<!DOCTYPE>
 <html>
 	<head>
@@ -439,8 +753,19 @@ func defaultErrorHandler(ctx *Context, e *ErrorResponse) []reflect.Value {
 	</body>
 </html>`
 	detail := ""
+	if len(e.Fields) > 0 {
+		detail = "<ul>"
+		for _, f := range e.Fields {
+			if f.Field != "" {
+				detail += "<li>" + html.EscapeString(f.Field) + ": " + html.EscapeString(f.Message) + "</li>"
+			} else {
+				detail += "<li>" + html.EscapeString(f.Message) + "</li>"
+			}
+		}
+		detail += "</ul>"
+	}
 	if Config.Debug && e.Stack != "" {
-		detail = fmt.Sprintf("<div>%s</div><pre>%s</pre>", e.Message, e.Stack)
+		detail += fmt.Sprintf("<div>%s</div><pre>%s</pre>", e.Message, e.Stack)
 	}
 	res := fmt.Sprintf(s, e.StatusCode(), e.StatusCode(), string(e.Content), detail)
 	return []reflect.Value{reflect.ValueOf(res)}
@@ -450,8 +775,14 @@ func defaultErrorHandler(ctx *Context, e *ErrorResponse) []reflect.Value {
 // Routing
 
 type route struct {
-	re      *regexp.Regexp
-	targets map[string]wrappedTarget
+	re       *regexp.Regexp
+	prog     *syntax.Prog
+	targets  map[string]Handler
+	name     string
+	cors     *corsPolicy
+	mount    bool
+	priority int
+	seq      int
 }
 
 func newRoute(pattern string) (*route, error) {
@@ -459,25 +790,53 @@ func newRoute(pattern string) (*route, error) {
 	if err != nil {
 		return nil, err
 	}
+	// prog is only used to detect overlapping patterns, so a pattern that
+	// can't be compiled for the comparison is simply never reported.
+	prog, _ := compileOverlapProg(pattern)
 	return &route{
 		re:      re,
-		targets: make(map[string]wrappedTarget),
+		prog:    prog,
+		targets: make(map[string]Handler),
 	}, nil
 }
 
-func (r *route) AddTarget(method string, target wrappedTarget) {
+func (r *route) AddTarget(method string, target Handler) {
 	r.targets[strings.ToUpper(method)] = target
 }
 
-func (r *route) Parse(path string) []string {
-	values := r.re.FindStringSubmatch(path)
-	if len(values) == 0 {
-		return nil
+// Parse returns the values of the route's capture groups for path, or nil if
+// the route doesn't match it. matched reports which groups took part in the
+// match, as a group in an optional expression may not.
+func (r *route) Parse(path string) (args []string, matched []bool) {
+	loc := r.re.FindStringSubmatchIndex(path)
+	if loc == nil {
+		return nil, nil
+	}
+	n := len(loc)/2 - 1
+	args = make([]string, n)
+	matched = make([]bool, n)
+	for i := 0; i < n; i++ {
+		if start := loc[2*i+2]; start >= 0 {
+			args[i] = path[start:loc[2*i+3]]
+			matched[i] = true
+		}
+	}
+	return args, matched
+}
+
+// Params maps the names of the route's named capture groups to their values
+// in args. Groups that didn't take part in the match are left out.
+func (r *route) Params(args []string, matched []bool) map[string]string {
+	params := make(map[string]string)
+	for i, name := range r.re.SubexpNames()[1:] {
+		if name != "" && i < len(args) && (i >= len(matched) || matched[i]) {
+			params[name] = args[i]
+		}
 	}
-	return values[1:]
+	return params
 }
 
-func (r *route) TargetForMethod(method string) wrappedTarget {
+func (r *route) TargetForMethod(method string) Handler {
 	method = strings.ToUpper(method)
 
 	// target for method exists explicitly
@@ -500,54 +859,192 @@ func (r *route) TargetForMethod(method string) wrappedTarget {
 	return nil
 }
 
+// allowedMethods returns the methods the route has targets for, including
+// HEAD if it has a GET target. An ANY target isn't included.
+func (r *route) allowedMethods() []string {
+	var methods []string
+	for method := range r.targets {
+		if method != "ANY" {
+			methods = append(methods, method)
+		}
+	}
+	if _, ok := r.targets["GET"]; ok {
+		if _, ok := r.targets["HEAD"]; !ok {
+			methods = append(methods, "HEAD")
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// Overlaps reports whether there is a path that is matched by both routes.
+func (r *route) Overlaps(other *route) bool {
+	if r.prog == nil || other.prog == nil {
+		return false
+	}
+	return progsOverlap(r.prog, other.prog)
+}
+
 func (r *route) String() string {
 	return fmt.Sprint(r.re)
 }
 
+// router holds the routes in the order they are matched: highest priority
+// first, and in the order they were added when the priorities are equal.
 type router struct {
-	routes map[string]route
+	routes   []*route
+	patterns map[string]*route
+	names    map[string]*route
+	nextSeq  int
 }
 
 func newRouter() *router {
-	return &router{routes: make(map[string]route)}
+	return &router{
+		patterns: make(map[string]*route),
+		names:    make(map[string]*route),
+	}
 }
 
-func (r *router) AddRoute(pattern, method string, target wrappedTarget) error {
-	route, ok := r.routes[pattern]
+// AddRoute returns the route for pattern, creating it if it doesn't exist
+// yet.
+func (r *router) AddRoute(pattern string) (*route, error) {
+	route, ok := r.patterns[pattern]
 	if !ok {
-		newRoute, err := newRoute(pattern)
+		var err error
+		route, err = newRoute(pattern)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		r.routes[pattern] = *newRoute
-		route = *newRoute
+		route.seq = r.nextSeq
+		r.nextSeq++
+		r.patterns[pattern] = route
+		r.routes = append(r.routes, route)
+		r.sort()
+	}
+	return route, nil
+}
+
+// SetPriority changes the priority of the route for pattern.
+func (r *router) SetPriority(pattern string, priority int) {
+	if route, ok := r.patterns[pattern]; ok && route.priority != priority {
+		route.priority = priority
+		r.sort()
 	}
-	route.AddTarget(method, target)
+}
+
+// SetName gives the route for pattern a name that can be used to reverse it.
+func (r *router) SetName(pattern, name string) error {
+	route, ok := r.patterns[pattern]
+	if !ok {
+		return fmt.Errorf("route '%s' not found", pattern)
+	}
+	if other, ok := r.names[name]; ok && other != route {
+		return fmt.Errorf("route name '%s' is already used by %s", name, other)
+	}
+	r.names[name] = route
+	route.name = name
 	return nil
 }
 
-func (r *router) GetRoute(pattern string) (route, bool) {
-	rt, ok := r.routes[pattern]
+func (r *router) sort() {
+	sort.Sort(byPriority(r.routes))
+}
+
+func (r *router) GetRoute(pattern string) (*route, bool) {
+	rt, ok := r.patterns[pattern]
+	return rt, ok
+}
+
+func (r *router) NamedRoute(name string) (*route, bool) {
+	rt, ok := r.names[name]
 	return rt, ok
 }
 
-func (r *router) FindTarget(path, method string) (wrappedTarget, []string) {
-	var args []string
-	var route route
-	for _, route = range r.routes {
-		args = route.Parse(path)
-		if args != nil {
-			break
+// Overlapping returns the other routes that match at least one of the paths
+// matched by the route for pattern.
+func (r *router) Overlapping(pattern string) []*route {
+	rt, ok := r.patterns[pattern]
+	if !ok {
+		return nil
+	}
+	var routes []*route
+	for _, other := range r.routes {
+		if other != rt && rt.Overlaps(other) {
+			routes = append(routes, other)
+		}
+	}
+	return routes
+}
+
+// FindTarget returns the first route that matches path and accepts method,
+// along with its target and the arguments parsed from path.
+//
+// If no route matches the path a 404 error is raised. If routes match but
+// none of them accept the method a 405 error is raised, with an Allow header
+// listing the methods they do accept.
+//
+// An OPTIONS request is answered with the Allow header by an automatic
+// target, unless one of the routes has an OPTIONS target or the path belongs
+// to a mounted App, which answers the request itself.
+func (r *router) FindTarget(path, method string) (*route, Handler, []string, []bool) {
+	method = strings.ToUpper(method)
+	var matched *route
+	var matchedArgs []string
+	var matchedGroups []bool
+	var allowed []string
+	for _, route := range r.routes {
+		args, groups := route.Parse(path)
+		if args == nil {
+			continue
+		}
+		if method == "OPTIONS" && route.mount && matched == nil {
+			return route, route.targets["ANY"], args, groups
+		}
+		if method != "OPTIONS" || route.targets["OPTIONS"] != nil {
+			if target := route.TargetForMethod(method); target != nil {
+				return route, target, args, groups
+			}
+		}
+		if matched == nil {
+			matched, matchedArgs, matchedGroups = route, args, groups
+		}
+		if _, ok := route.targets["ANY"]; ok {
+			allowed = append(allowed, anyMethods...)
+		} else {
+			allowed = append(allowed, route.allowedMethods()...)
 		}
 	}
-	if args == nil {
+	if matched == nil {
 		Abort(404, "Not Found")
 	}
-	target := route.TargetForMethod(method)
-	if target == nil {
-		Abort(405, "Method not allowed")
+
+	allowed = append(allowed, "OPTIONS")
+	sort.Strings(allowed)
+	allow := strings.Join(uniqueMethods(allowed), ", ")
+	if method == "OPTIONS" {
+		return matched, HandlerFunc(func(ctx *Context) *Response {
+			ctx.Response.Code = 204
+			ctx.Response.Header().Set("Allow", allow)
+			return ctx.Response
+		}), matchedArgs, matchedGroups
+	}
+	e := NewError(405, "Method Not Allowed")
+	e.Header().Set("Allow", allow)
+	panic(e)
+}
+
+// anyMethods are the methods allowed by an ANY target.
+var anyMethods = []string{"DELETE", "GET", "HEAD", "PATCH", "POST", "PUT"}
+
+type byPriority []*route
+
+func (p byPriority) Len() int      { return len(p) }
+func (p byPriority) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p byPriority) Less(i, j int) bool {
+	if p[i].priority != p[j].priority {
+		return p[i].priority > p[j].priority
 	}
-	return target, args
+	return p[i].seq < p[j].seq
 }
 
 func (r *route) StripPattern(path string) string {
@@ -566,6 +1063,28 @@ type Handler interface {
 type App struct {
 	router        router
 	errorHandlers map[int]wrappedErrorHandler
+	mounts        []mount
+	middleware    []Middleware
+	plugins       []Plugin
+	registrations []registration
+	templates     *templateSet
+	cors          *corsPolicy
+}
+
+// registration records a target added to a route so it can be wrapped
+// again when plugins are installed.
+type registration struct {
+	pattern string
+	method  string
+	target  wrappedTarget
+	options *RouteOptions
+}
+
+// mount records an App mounted inside another so its named routes can be
+// reversed.
+type mount struct {
+	pattern string
+	app     *App
 }
 
 // Creates a new empty App
@@ -575,57 +1094,163 @@ func NewApp() *App {
 	return a
 }
 
+// RouteOptions holds the optional settings for a route.
+//
+// Name identifies the route so a path to it can be built with URL.
+//
+// Priority controls the order routes are matched in. Routes with a higher
+// priority are tried first. Routes with the same priority are tried in the
+// order they were added. The default priority is 0.
+//
+// Middleware wraps the target, or the App when used with MountWithOptions.
+// Skip lists the App's plugins that aren't applied to the target.
+//
+// API marks the target as part of an API, so errors are rendered as
+// application/problem+json whatever the request's Accept header. With
+// MountWithOptions it applies to every route of the mounted App.
+//
+// CORS is the route's CORS policy, which replaces the App's. With
+// MountWithOptions it applies to the routes of the mounted App, unless it has
+// a policy of its own. See App.SetCORS.
+//
+// Middleware, Skip and API apply only to the method the options are given
+// with. Name, Priority and CORS apply to every method of the route, so
+// giving a different Priority or CORS policy for another method of the same
+// pattern is an error. Leaving them unset keeps the ones given before.
+type RouteOptions struct {
+	Name       string
+	Priority   int
+	Middleware []Middleware
+	Skip       []Plugin
+	API        bool
+	CORS       *CORSOptions
+}
+
 // addRoute takes a target and saves it in the router.
 //
 // It also wraps up the target in code that makes it easier to call
-func (a *App) addRoute(pattern, method string, target Target) error {
+func (a *App) addRoute(pattern, method string, target Target, options *RouteOptions) error {
 	callable := wrapTarget(target)
-	return a.router.AddRoute(pattern, method, callable)
+	existing, exists := a.router.GetRoute(pattern)
+	var cors *corsPolicy
+	if options != nil {
+		var err error
+		if cors, err = newCORSPolicy(options.CORS); err != nil {
+			return err
+		}
+		if exists {
+			if err := checkRouteOptions(existing, pattern, options); err != nil {
+				return err
+			}
+		}
+	}
+	route, err := a.router.AddRoute(pattern)
+	if err != nil {
+		return err
+	}
+	reg := registration{pattern: pattern, method: strings.ToUpper(method), target: callable, options: options}
+	a.registrations = append(a.registrations, reg)
+
+	if options != nil {
+		if cors != nil {
+			route.cors = cors
+		}
+		if options.Name != "" {
+			if err := a.router.SetName(pattern, options.Name); err != nil {
+				return err
+			}
+		}
+		if options.Priority != 0 {
+			a.router.SetPriority(pattern, options.Priority)
+		}
+		// the route seen by the plugins applied to its other targets may
+		// have changed
+		for _, other := range a.registrations {
+			if other.pattern == pattern {
+				a.compileTarget(other)
+			}
+		}
+	} else {
+		a.compileTarget(reg)
+	}
+
+	// comparing every pair of routes is slow, so only when it's logged
+	if !exists && Config.Debug && Config.Logging {
+		for _, other := range a.router.Overlapping(pattern) {
+			debugf("Route %s overlaps with route %s", pattern, other)
+		}
+	}
+	return nil
+}
+
+// checkRouteOptions returns an error if options conflict with the options
+// given for the route's other methods.
+func checkRouteOptions(route *route, pattern string, options *RouteOptions) error {
+	if options.Priority != 0 && route.priority != 0 && options.Priority != route.priority {
+		return fmt.Errorf("route '%s' already has priority %d", pattern, route.priority)
+	}
+	if options.CORS != nil && route.cors != nil && !reflect.DeepEqual(*options.CORS, route.cors.options) {
+		return fmt.Errorf("route '%s' already has a different CORS policy", pattern)
+	}
+	return nil
+}
+
+// Map a function to a url pattern for requests with the given method ("ANY"
+// matches all methods) with the given options. See RouteOptions.
+func (a *App) RouteWithOptions(pattern, method string, target Target, options *RouteOptions) error {
+	return a.addRoute(pattern, method, target, options)
 }
 
 // Map a function to a url pattern for any request method
 func (a *App) Route(pattern string, target Target) error {
-	return a.addRoute(pattern, "ANY", target)
+	return a.addRoute(pattern, "ANY", target, nil)
 }
 
 // Map a function to a url pattern for DELETE requests
 func (a *App) Delete(pattern string, target Target) error {
-	return a.addRoute(pattern, "DELETE", target)
+	return a.addRoute(pattern, "DELETE", target, nil)
 }
 
 // Map a function to a url pattern for GET requests
 func (a *App) Get(pattern string, target Target) error {
-	return a.addRoute(pattern, "GET", target)
+	return a.addRoute(pattern, "GET", target, nil)
 }
 
 // Map a function to a url pattern for HEAD requests
 func (a *App) Head(pattern string, target Target) error {
-	return a.addRoute(pattern, "HEAD", target)
+	return a.addRoute(pattern, "HEAD", target, nil)
 }
 
 // Map a function to a url pattern for PATCH requests
 func (a *App) Patch(pattern string, target Target) error {
-	return a.addRoute(pattern, "PATCH", target)
+	return a.addRoute(pattern, "PATCH", target, nil)
 }
 
 // Map a function to a url pattern for POST requests
 func (a *App) Post(pattern string, target Target) error {
-	return a.addRoute(pattern, "POST", target)
+	return a.addRoute(pattern, "POST", target, nil)
 }
 
 // Map a function to a url pattern for PUT requests
 func (a *App) Put(pattern string, target Target) error {
-	return a.addRoute(pattern, "PUT", target)
+	return a.addRoute(pattern, "PUT", target, nil)
 }
 
-// Map a function to a url pattern for OPTIONS requests
+// Map a function to a url pattern for OPTIONS requests. Without one OPTIONS
+// requests are answered with an Allow header listing the route's methods.
 func (a *App) Options(pattern string, target Target) error {
-	return a.addRoute(pattern, "OPTIONS", target)
+	return a.addRoute(pattern, "OPTIONS", target, nil)
 }
 
 // Mount an application (uweb.App or anything that implements
 // the Handler interface) at a specific url pattern
 func (a *App) Mount(pattern string, handler Handler) error {
+	return a.MountWithOptions(pattern, handler, nil)
+}
+
+// Mount an application at a specific url pattern and apply the options to
+// the pattern's route.
+func (a *App) MountWithOptions(pattern string, handler Handler, options *RouteOptions) error {
 
 	wrapper := func(ctx *Context) *Response {
 		r, _ := a.router.GetRoute(pattern)
@@ -633,7 +1258,68 @@ func (a *App) Mount(pattern string, handler Handler) error {
 		return handler.Handle(ctx)
 	}
 
-	return a.addRoute(pattern, "ANY", wrapper)
+	if err := a.addRoute(pattern, "ANY", wrapper, options); err != nil {
+		return err
+	}
+	r, _ := a.router.GetRoute(pattern)
+	r.mount = true
+	if app, ok := handler.(*App); ok {
+		a.mounts = append(a.mounts, mount{pattern: pattern, app: app})
+	}
+	return nil
+}
+
+// URL builds the path to the route with the given name. The args are
+// formatted with fmt.Sprint and used to fill in the route pattern's capture
+// groups in order.
+//
+// Routes in Apps mounted with Mount are also searched, in which case the
+// first args fill in the capture groups of the mount's pattern.
+//
+//    app.RouteWithOptions("^blog/([0-9]+)/$", "GET", BlogView,
+//        &uweb.RouteOptions{Name: "blog"})
+//
+//    path, err := app.URL("blog", 42) // "/blog/42/"
+func (a *App) URL(name string, args ...interface{}) (string, error) {
+	strArgs := make([]string, len(args))
+	for i, arg := range args {
+		strArgs[i] = fmt.Sprint(arg)
+	}
+	path, found, err := a.reverse(name, strArgs)
+	if !found {
+		return "", fmt.Errorf("route named '%s' not found", name)
+	}
+	if err != nil {
+		return "", err
+	}
+	u := url.URL{Path: "/" + path}
+	return u.EscapedPath(), nil
+}
+
+// reverse finds the route with the given name and builds the path to it,
+// relative to the App. found is false if the route doesn't exist.
+func (a *App) reverse(name string, args []string) (path string, found bool, err error) {
+	if r, ok := a.router.NamedRoute(name); ok {
+		path, err = r.Reverse(args)
+		return path, true, err
+	}
+	for _, m := range a.mounts {
+		r, _ := a.router.GetRoute(m.pattern)
+		n := r.re.NumSubexp()
+		if n > len(args) {
+			continue
+		}
+		path, found, err = m.app.reverse(name, args[n:])
+		if !found {
+			continue
+		}
+		if err != nil {
+			return "", true, err
+		}
+		prefix, err := r.Reverse(args[:n])
+		return prefix + path, true, err
+	}
+	return "", false, nil
 }
 
 // Register a handler to be called when an ErrorResponse is returned
@@ -646,33 +1332,62 @@ func (a *App) Error(code int, handler ErrorHandler) {
 // This method will clear all the routes, mounts, error handlers, etc.
 func (a *App) Reset() {
 	a.router = *newRouter()
-}
+	a.mounts = nil
+	a.middleware = nil
+	a.plugins = nil
+	a.registrations = nil
+	a.templates = nil
+	a.cors = nil
+}
+
+// dispatch finds the route matching the request and calls its target,
+// wrapped in the route's middleware.
+func (a *App) dispatch(ctx *Context) *Response {
+	if a.cors != nil {
+		ctx.setCORS(a.cors)
+	}
+	if resp := a.preflight(ctx); resp != nil {
+		return resp
+	}
 
-// find and call wraps up the process of path matching and calling the target
-// so that we can capture any error responses that are generated for processing
-//
-// BUG(calebbrown): consider refactoring this process into one that is more
-// suited to being used in a variety of contexts.
-func (a *App) findAndCall(ctx *Context) (results []reflect.Value) {
-	defer func() {
-		if err := recover(); err != nil {
-			results = make([]reflect.Value, 1)
-			if response, ok := err.(*Response); ok {
-				results[0] = reflect.ValueOf(response)
-			} else if response, ok := err.(*ErrorResponse); ok {
-				results[0] = reflect.ValueOf(response)
-			} else {
-				response := NewError(500, fmt.Sprint(err))
-				response.Content = []byte("Internal Server Error")
-				response.SetStack(true)
-				results[0] = reflect.ValueOf(response)
-			}
-		}
-	}()
+	route, target, args, matched := a.router.FindTarget(ctx.Path, ctx.Method)
+	ctx.Args = args
+	ctx.argNames = route.re.SubexpNames()[1:]
+	ctx.argMatched = matched
+	for name, value := range route.Params(args, matched) {
+		ctx.Params[name] = value
+	}
 
-	target, args := a.router.FindTarget(ctx.Path, ctx.Method)
+	if route.cors != nil {
+		ctx.setCORS(route.cors)
+	}
+	return target.Handle(ctx)
+}
+
+// recoverer wraps a handler so that any error responses or redirects raised
+// with panic are captured and converted into a response, so that the
+// middleware around the handler always sees a response.
+func (a *App) recoverer(next Handler) Handler {
+	return HandlerFunc(func(ctx *Context) (resp *Response) {
+		defer func() {
+			if err := recover(); err != nil {
+				results := make([]reflect.Value, 1)
+				if response, ok := err.(*Response); ok {
+					results[0] = reflect.ValueOf(response)
+				} else if response, ok := err.(*ErrorResponse); ok {
+					results[0] = reflect.ValueOf(response)
+				} else {
+					response := NewError(500, fmt.Sprint(err))
+					response.Content = []byte("Internal Server Error")
+					response.SetStack(true)
+					results[0] = reflect.ValueOf(response)
+				}
+				resp = a.cast(ctx, results)
+			}
+		}()
 
-	return target(ctx, args...)
+		return next.Handle(ctx)
+	})
 }
 
 // cast takes a return value from a target or error handler and attempts to
@@ -681,6 +1396,15 @@ func (a *App) cast(ctx *Context, results []reflect.Value) *Response {
 	if len(results) == 0 {
 		return ctx.Response
 	}
+	if last := results[len(results)-1]; last.Type() == errorType {
+		if !last.IsNil() {
+			return a.cast(ctx, []reflect.Value{reflect.ValueOf(errorResponse(last.Interface().(error)))})
+		}
+		results = results[:len(results)-1]
+		if len(results) == 0 {
+			return ctx.Response
+		}
+	}
 	if len(results) > 1 {
 		panic("Too many values returned from target")
 	}
@@ -704,27 +1428,37 @@ func (a *App) cast(ctx *Context, results []reflect.Value) *Response {
 	case *Response:
 		r, _ := result.(*Response)
 		return r
+	case TemplateResult:
+		a.renderTemplate(ctx, result.(TemplateResult))
 	case io.Reader:
 		r, _ := result.(io.Reader)
-		var b bytes.Buffer
-		b.ReadFrom(r)
-		ctx.Response.Content = b.Bytes()
+		ctx.Response.Body = r
+	case EventSource:
+		source, _ := result.(EventSource)
+		setEventStream(ctx.Response, ctx, source)
+	case <-chan Event:
+		events, _ := result.(<-chan Event)
+		setEventStream(ctx.Response, ctx, channelSource(events))
+	case chan Event:
+		events, _ := result.(chan Event)
+		setEventStream(ctx.Response, ctx, channelSource(events))
 	default:
-		// attempt to return a JSON data response
-		json_content, err := json.Marshal(result)
-		if err != nil {
-			panic("Unknown response type returned from view function")
-		}
-		ctx.Response.Content = json_content
-		ctx.Response.Header().Set("Content-Type", "application/json")
+		// encode the value for the media type the client prefers
+		encodeResult(ctx, result)
 	}
 
 	return ctx.Response
 }
 
 func (a *App) Handle(ctx *Context) *Response {
-	results := a.findAndCall(ctx)
-	resp := a.cast(ctx, results)
+	handler := a.recoverer(HandlerFunc(a.dispatch))
+	resp := a.chain(a.middleware, handler).Handle(ctx)
+	if resp == nil {
+		return nil
+	}
+	if ctx.cors != nil {
+		ctx.cors.apply(ctx, resp)
+	}
 	// Flag the content to only be written if the request isn't "HEAD"
 	resp.WriteContent = strings.ToUpper(ctx.Method) != "HEAD"
 	return resp
@@ -734,13 +1468,25 @@ func (a *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var resp responseWriter
 
 	ctx := NewContext(r)
+	ctx.Writer = w
+	ctx.app = a
+	defer ctx.removeFiles()
 	ctx.Path = ctx.Path[1:] // remove the proceeding slash
 
 	resp = a.Handle(ctx)
 	if resp == nil {
 		resp = NewError(404, "Page Not Found")
 	}
-	resp.WriteResponse(w)
+	// don't write anything if the target has already streamed a response
+	if ctx.Response.written {
+		resp = ctx.Response
+	}
+	// middleware may have wrapped the writer, such as to compress the
+	// response, in which case it is closed once the response is written
+	resp.WriteResponse(ctx.Writer)
+	if c, ok := ctx.Writer.(io.Closer); ok {
+		c.Close()
+	}
 
 	logf("%s %s [%d]", r.Method, r.RequestURI, resp.StatusCode())
 }
@@ -773,11 +1519,33 @@ var DefaultApp *App
 // When AutoReload is set to true, and Debug is set to true a call to Run()
 // will wrap the execution up so that when a change is detected on a dependency
 // it will restart the execution of the web application.
+//
+// EventKeepAlive is how often a comment is sent on an idle event stream to
+// keep the connection open. See NewEventStream.
+//
+// MultipartMemory is how many bytes of a multipart form are kept in memory,
+// the rest is stored in temporary files. See Context.Files.
+//
+// MaxBodySize is the largest request body, in bytes, that is read for a
+// url-encoded form or when binding a struct argument. MaxMultipartSize is the
+// largest multipart form, including the files stored on disk. Larger requests
+// are aborted with a 413 error. Zero means there is no limit.
+//
+// CookieKeys are the keys used for secure cookies. The first key signs new
+// cookies and the rest are only used to check them, so keys can be rotated.
+// When EncryptCookies is set new secure cookies are also encrypted. See
+// Response.SetSecureCookie.
 var Config struct {
-	Debug         bool
-	AutoReload    bool
-	Logging       bool
-	CookieOptions *CookieOptions
+	Debug            bool
+	AutoReload       bool
+	Logging          bool
+	CookieOptions    *CookieOptions
+	CookieKeys       [][]byte
+	EncryptCookies   bool
+	EventKeepAlive   time.Duration
+	MultipartMemory  int64
+	MaxBodySize      int64
+	MaxMultipartSize int64
 }
 
 func Route(pattern string, target Target) error {
@@ -812,10 +1580,46 @@ func Options(pattern string, target Target) error {
 	return DefaultApp.Options(pattern, target)
 }
 
+func RouteWithOptions(pattern, method string, target Target, options *RouteOptions) error {
+	return DefaultApp.RouteWithOptions(pattern, method, target, options)
+}
+
+func WebSocket(pattern string, target Target) error {
+	return DefaultApp.WebSocket(pattern, target)
+}
+
+func Static(pattern string, root interface{}) error {
+	return DefaultApp.Static(pattern, root)
+}
+
+func LoadTemplates(root interface{}) error {
+	return DefaultApp.LoadTemplates(root)
+}
+
+func SetCORS(options *CORSOptions) error {
+	return DefaultApp.SetCORS(options)
+}
+
 func Mount(pattern string, handler Handler) error {
 	return DefaultApp.Mount(pattern, handler)
 }
 
+func MountWithOptions(pattern string, handler Handler, options *RouteOptions) error {
+	return DefaultApp.MountWithOptions(pattern, handler, options)
+}
+
+func URL(name string, args ...interface{}) (string, error) {
+	return DefaultApp.URL(name, args...)
+}
+
+func Use(middleware ...Middleware) {
+	DefaultApp.Use(middleware...)
+}
+
+func Install(plugin Plugin) {
+	DefaultApp.Install(plugin)
+}
+
 func Error(code int, handler ErrorHandler) {
 	DefaultApp.Error(code, handler)
 }
@@ -872,6 +1676,10 @@ func init() {
 	Config.Debug = false
 	Config.AutoReload = false
 	Config.CookieOptions = NewCookieOptions()
+	Config.EventKeepAlive = 15 * time.Second
+	Config.MultipartMemory = 8 << 20
+	Config.MaxBodySize = 10 << 20
+	Config.MaxMultipartSize = 100 << 20
 }
 
 // RedirectWithCode behaves like Redirect, but allows a custom HTTP
@@ -910,8 +1718,6 @@ func Abort(code int, message string) {
 }
 
 
-// BUG(calebbrown): add middleware/plugin capability
-
 // BUG(calebbrown): improve configurability
 
 // BUG(calebbrown): capture errors in non-debug mode
@@ -922,4 +1728,3 @@ func Abort(code int, message string) {
 
 // BUG(calebbrown): add ability to merge responses together
 
-// BUG(calebbrown): Form() and Query() methods in the context
@@ -5,12 +5,18 @@
 package uweb_test
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"github.com/calebbrown/uweb"
 	"io"
+	go_log "log"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 func simpleView1() string {
@@ -45,6 +51,38 @@ func simpleView8() *strings.Reader {
 	return strings.NewReader("hello world")
 }
 
+func typedView(id int, ratio float64, enabled bool, day time.Time) string {
+	return fmt.Sprintf("%d %.2f %t %s", id+1, ratio, enabled, day.Format("Jan 2"))
+}
+
+func optionalView(id int) string {
+	return fmt.Sprint(id)
+}
+
+func typedVariadicView(ctx *uweb.Context, ids ...uint8) string {
+	return fmt.Sprint(ids)
+}
+
+type Color string
+
+func colorView(c Color) string {
+	return string(c)
+}
+
+type PostArgs struct {
+	UserID int `path:"id"`
+	Slug   string
+	Draft  bool `path:"-"`
+}
+
+func postView(ctx *uweb.Context, args PostArgs) string {
+	return fmt.Sprintf("%d %s %t", args.UserID, args.Slug, args.Draft)
+}
+
+func postPtrView(args *PostArgs, page int) string {
+	return fmt.Sprintf("%d %s %d", args.UserID, args.Slug, page)
+}
+
 type TestStruct struct {
 	Name string
 }
@@ -53,6 +91,33 @@ func simpleView9() TestStruct {
 	return TestStruct{Name: "Hello"}
 }
 
+// statusError is an error carrying a status code.
+type statusError struct {
+	code int
+}
+
+func (e statusError) Error() string   { return "status error" }
+func (e statusError) StatusCode() int { return e.code }
+
+func errorValueView(kind string) (TestStruct, error) {
+	switch kind {
+	case "status":
+		return TestStruct{}, fmt.Errorf("wrapped: %w", statusError{409})
+	case "response":
+		return TestStruct{}, uweb.NewError(418, "short and stout")
+	case "plain":
+		return TestStruct{}, errors.New("database password is hunter2")
+	}
+	return TestStruct{Name: kind}, nil
+}
+
+func errorOnlyView(kind string) error {
+	if kind == "fail" {
+		return statusError{403}
+	}
+	return nil
+}
+
 func notFoundView() {
 	uweb.Abort(404, "Page Not Found")
 }
@@ -98,11 +163,20 @@ func cookieDelete(ctx *uweb.Context) string {
 	return "OK"
 }
 
-var app *uweb.App
+var app = uweb.NewApp()
 
 func init() {
+	uweb.RegisterArgParser(Color(""), func(s string) (interface{}, error) {
+		switch s {
+		case "red", "green", "blue":
+			return Color(s), nil
+		case "purple":
+			return nil, uweb.NewError(400, "bad color")
+		}
+		return nil, errors.New("unknown color")
+	})
+
 	uweb.Config.Logging = false
-	app = uweb.NewApp()
 	app.Route("^view1/$", simpleView1)
 	app.Route("^view2/$", simpleView2)
 	app.Route("^view3/$", simpleView3)
@@ -131,12 +205,57 @@ func init() {
 	app.Head("^head1/$", func() string { return "test head" })
 	app.Get("^head2/$", func() string { return "test get" })
 
+	app.Get("^order/(.*)/$", func() string { return "first" })
+	app.Get("^order/fixed/$", func() string { return "second" })
+	app.Get("^order/([a-z]+)/$", func() string { return "third" })
+	app.RouteWithOptions("^order/priority/$", "GET", func() string {
+		return "priority"
+	}, &uweb.RouteOptions{Priority: 10})
+
+	app.Get("^fallthrough/(.*)/$", func() string { return "get" })
+	app.Post("^fallthrough/post/$", func() string { return "post" })
+
+	app.Get("^typed/([0-9]+)/([0-9.]+)/([a-z]+)/([0-9-]+)/$", typedView)
+	app.Get("^typed/variadic/([0-9]+)/([0-9]+)/$", typedVariadicView)
+	app.Get("^typed/color/([a-z]+)/$", colorView)
+	app.Get("^typed/optional(?:/([0-9]+))?/$", optionalView)
+
+	app.Get("^errors/value/([a-z]+)/$", errorValueView)
+	app.Get("^errors/only/([a-z]+)/$", errorOnlyView)
+
+	app.Get("^users/(?P<id>[0-9]+)/posts/(?P<slug>[a-z-]+)/$", postView)
+	app.Get("^users/(?P<id>[0-9]+)/posts/(?P<slug>[a-z-]+)/([0-9]+)/$", postPtrView)
+	app.Get("^users/(?P<id>[0-9]+)/draft/(?P<draft>true)/$", postView)
+	app.Get("^posts(?:/(?P<id>[0-9]+))?/(?P<slug>[a-z-]+)/$", postView)
+
+	userApp := uweb.NewApp()
+	app.Mount("^members/(?P<id>[0-9]+)/", userApp)
+	userApp.Get("^posts/(?P<slug>[a-z-]+)/$", postView)
+
 	app.Error(401, error401)
 
 	subApp := uweb.NewApp()
 	app.Mount("^sub/", subApp)
 
 	subApp.Get("^view/$", simpleView1)
+	subApp.RouteWithOptions("^named/$", "GET", simpleView1,
+		&uweb.RouteOptions{Name: "sub-named"})
+
+	app.RouteWithOptions("^blog/([0-9]+)/([a-z-]+)/?$", "GET", simpleView6,
+		&uweb.RouteOptions{Name: "blog"})
+	app.RouteWithOptions("^(?:en|fr)/about\\.html$", "GET", simpleView1,
+		&uweb.RouteOptions{Name: "about"})
+	app.RouteWithOptions("^users(?:/([0-9]+))?/$", "GET", simpleView4,
+		&uweb.RouteOptions{Name: "users"})
+	app.RouteWithOptions("^(?:list|item/([0-9]+))/$", "GET", simpleView4,
+		&uweb.RouteOptions{Name: "list-or-item"})
+	app.RouteWithOptions("^(?:archive/([0-9]+)|tag/([a-z]+))/$", "GET", simpleView6,
+		&uweb.RouteOptions{Name: "archive-or-tag"})
+
+	tenantApp := uweb.NewApp()
+	app.Mount("^tenant/([a-z]+)/", tenantApp)
+	tenantApp.RouteWithOptions("^items/([0-9]+)/$", "GET", simpleView4,
+		&uweb.RouteOptions{Name: "tenant-item"})
 }
 
 func doRequest(req *http.Request) *httptest.ResponseRecorder {
@@ -146,8 +265,28 @@ func doRequest(req *http.Request) *httptest.ResponseRecorder {
 }
 
 func doSimpleRequest(method, url string, body io.Reader) *httptest.ResponseRecorder {
+	return serve(app, method, url, body, nil)
+}
+
+// serve sends a request with the body and headers to an App and returns the
+// response.
+func serve(a *uweb.App, method, url string, body io.Reader, header map[string]string) *httptest.ResponseRecorder {
 	req, _ := http.NewRequest(method, url, body)
-	return doRequest(req)
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	out := httptest.NewRecorder()
+	a.ServeHTTP(out, req)
+	return out
+}
+
+// cookieHeader returns the header that sends a cookie with a request, or nil
+// if cookie is nil.
+func cookieHeader(cookie *http.Cookie) map[string]string {
+	if cookie == nil {
+		return nil
+	}
+	return map[string]string{"Cookie": cookie.Name + "=" + cookie.Value}
 }
 
 func TestPackageRoutingMethods(t *testing.T) {
@@ -290,8 +429,8 @@ func TestInvalidInputs(t *testing.T) {
 		}
 	}()
 	// this will fail with a panic. these functions are invalid
-	uweb.Route("^test_fail", func(foo int) int {
-		return foo + 1
+	uweb.Route("^test_fail", func(foo chan int) int {
+		return <-foo + 1
 	})
 }
 
@@ -324,7 +463,322 @@ func TestCookieSet(t *testing.T) {
 func TestCookieDelete(t *testing.T) {
 	out := doSimpleRequest("GET", "/cookie/delete/", nil)
 	cookie := out.Header().Get("Set-Cookie")
-	if cookie != "test-key=; Path=/; Max-Age=0; HttpOnly" {
+	if cookie != "test-key=; Path=/; Expires=Thu, 01 Jan 1970 00:00:00 GMT; Max-Age=0; HttpOnly" {
 		t.Errorf("set-cookie header incorrect: %s", cookie)
 	}
 }
+
+func TestCookieOptions(t *testing.T) {
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name    string
+		options uweb.CookieOptions
+		header  string
+	}{
+		{"a", uweb.CookieOptions{Path: "/", SameSite: http.SameSiteLaxMode},
+			"a=v; Path=/; SameSite=Lax"},
+		{"a", uweb.CookieOptions{Path: "/", Expires: expires, HttpOnly: true, SameSite: http.SameSiteStrictMode},
+			"a=v; Path=/; Expires=Wed, 02 Jan 2030 03:04:05 GMT; HttpOnly; SameSite=Strict"},
+		{"a", uweb.CookieOptions{Path: "/", SameSite: http.SameSiteNoneMode},
+			"a=v; Path=/; Secure; SameSite=None"},
+		{"__Secure-a", uweb.CookieOptions{Path: "/app/", Domain: "example.com", Secure: true},
+			"__Secure-a=v; Path=/app/; Domain=example.com; Secure"},
+		{"__Host-a", uweb.CookieOptions{Path: "/", Secure: true},
+			"__Host-a=v; Path=/; Secure"},
+	}
+	for _, test := range tests {
+		r := uweb.NewResponse()
+		r.SetCookieWithOptions(test.name, "v", &test.options)
+		if header := r.Cookies[test.name].String(); header != test.header {
+			t.Errorf("set-cookie header incorrect: %s", header)
+		}
+	}
+
+	r := uweb.NewResponse()
+	for _, options := range []uweb.CookieOptions{{Path: "/", Expires: expires}, {Path: "/"}} {
+		r.DeleteCookieWithOptions("a", &options)
+		if header := r.Cookies["a"].String(); header != "a=; Path=/; Expires=Thu, 01 Jan 1970 00:00:00 GMT; Max-Age=0" {
+			t.Errorf("set-cookie header incorrect: %s", header)
+		}
+	}
+
+	// nil uses the default options
+	r.SetCookieWithOptions("a", "v", nil)
+	if header := r.Cookies["a"].String(); header != "a=v; Path=/; HttpOnly" {
+		t.Errorf("set-cookie header incorrect: %s", header)
+	}
+}
+
+func TestCookiePrefixRules(t *testing.T) {
+	invalid := map[string]uweb.CookieOptions{
+		"__Secure-a": {Path: "/"},
+		"__Host-a":   {Path: "/"},
+		"__Host-b":   {Path: "/app/", Secure: true},
+		"__Host-c":   {Path: "/", Domain: "example.com", Secure: true},
+	}
+	for name, options := range invalid {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: invalid cookie created", name)
+				}
+			}()
+			options.Cookie(name, "v")
+		}()
+	}
+}
+
+func TestAllowedMethods(t *testing.T) {
+	a := uweb.NewApp()
+	a.Get("^items/$", simpleView1)
+	a.Post("^items/$", simpleView1)
+	a.Delete("^items/([0-9]+)/$", simpleView1)
+	a.Put("^items/([a-z0-9]+)/$", simpleView1)
+	a.Route("^any/$", simpleView1)
+	a.Get("^custom/$", simpleView1)
+	a.Options("^custom/$", func() string { return "custom options" })
+	sub := uweb.NewApp()
+	sub.Get("^$", simpleView1)
+	a.Mount("^sub/", sub)
+	notAllowed := func(e *uweb.ErrorResponse) string {
+		return "not allowed, use " + e.Header().Get("Allow")
+	}
+	a.Error(405, notAllowed)
+	sub.Error(405, notAllowed)
+
+	tests := []struct {
+		method, url string
+		code        int
+		allow       string
+	}{
+		{"PUT", "/items/", 405, "GET, HEAD, OPTIONS, POST"},
+		{"OPTIONS", "/items/", 204, "GET, HEAD, OPTIONS, POST"},
+		{"GET", "/items/1/", 405, "DELETE, OPTIONS, PUT"},
+		{"OPTIONS", "/items/1/", 204, "DELETE, OPTIONS, PUT"},
+		{"OPTIONS", "/any/", 204, "DELETE, GET, HEAD, OPTIONS, PATCH, POST, PUT"},
+		{"POST", "/sub/", 405, "GET, HEAD, OPTIONS"},
+		{"OPTIONS", "/sub/", 204, "GET, HEAD, OPTIONS"},
+		{"OPTIONS", "/missing/", 404, ""},
+	}
+	for _, test := range tests {
+		out := serve(a, test.method, test.url, nil, nil)
+		if out.Code != test.code {
+			t.Errorf("%s %s: unexpected status code %d", test.method, test.url, out.Code)
+		}
+		if allow := out.Header().Get("Allow"); allow != test.allow {
+			t.Errorf("%s %s: unexpected Allow header %q", test.method, test.url, allow)
+		}
+		if test.code == 405 && out.Body.String() != "not allowed, use "+test.allow {
+			t.Errorf("%s %s: unexpected body %s", test.method, test.url, out.Body.String())
+		}
+	}
+
+	// an OPTIONS target replaces the automatic response
+	if out := serve(a, "OPTIONS", "/custom/", nil, nil); out.Body.String() != "custom options" || out.Header().Get("Allow") != "" {
+		t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+	}
+}
+
+func TestRouteOrder(t *testing.T) {
+	tests := map[string]string{
+		"/order/fixed/":    "first",
+		"/order/abc/":      "first",
+		"/order/priority/": "priority",
+	}
+
+	for url, expected := range tests {
+		for i := 0; i < 10; i++ {
+			out := doSimpleRequest("GET", url, nil)
+			if out.Body.String() != expected {
+				t.Errorf("%s handled by view %s, not %s", url, out.Body.String(), expected)
+			}
+		}
+	}
+}
+
+func TestRouteMethodFallthrough(t *testing.T) {
+	out := doSimpleRequest("POST", "/fallthrough/post/", nil)
+	if out.Body.String() != "post" {
+		t.Errorf("POST handled by view %s", out.Body.String())
+	}
+
+	out = doSimpleRequest("GET", "/fallthrough/post/", nil)
+	if out.Body.String() != "get" {
+		t.Errorf("GET handled by view %s", out.Body.String())
+	}
+
+	out = doSimpleRequest("POST", "/fallthrough/other/", nil)
+	if out.Code != 405 {
+		t.Errorf("Status code %d != 405", out.Code)
+	}
+}
+
+func TestOverlappingRoutes(t *testing.T) {
+	var buf bytes.Buffer
+	go_log.SetOutput(&buf)
+	uweb.Config.Logging = true
+	uweb.Config.Debug = true
+	defer func() {
+		go_log.SetOutput(os.Stderr)
+		uweb.Config.Logging = false
+		uweb.Config.Debug = false
+	}()
+
+	overlapApp := uweb.NewApp()
+	overlapApp.Get("^users/([0-9]+)/$", simpleView1)
+	overlapApp.Get("^users/new/$", simpleView1)
+	if buf.Len() != 0 {
+		t.Errorf("Unexpected overlap reported: %s", buf.String())
+	}
+
+	overlapApp.Get("^users/(.*)$", simpleView1)
+	if !strings.Contains(buf.String(), "^users/([0-9]+)/$") ||
+		!strings.Contains(buf.String(), "^users/new/$") {
+		t.Errorf("Overlap not reported: %s", buf.String())
+	}
+
+	buf.Reset()
+	overlapApp.Get("^(?i)USERS/NEW/edit$", simpleView1)
+	if !strings.Contains(buf.String(), "^users/(.*)$") ||
+		strings.Contains(buf.String(), "^users/new/$") {
+		t.Errorf("Unexpected overlaps reported: %s", buf.String())
+	}
+}
+
+func TestURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []interface{}
+		expected string
+	}{
+		{"blog", []interface{}{42, "hello-world"}, "/blog/42/hello-world"},
+		{"about", nil, "/en/about.html"},
+		{"sub-named", nil, "/sub/named/"},
+		{"tenant-item", []interface{}{"acme", 7}, "/tenant/acme/items/7/"},
+		{"users", []interface{}{5}, "/users/5/"},
+		{"list-or-item", []interface{}{7}, "/item/7/"},
+	}
+
+	for _, test := range tests {
+		url, err := app.URL(test.name, test.args...)
+		if err != nil {
+			t.Errorf("URL(%s) failed: %s", test.name, err)
+		} else if url != test.expected {
+			t.Errorf("URL(%s) = %s, expected %s", test.name, url, test.expected)
+		}
+	}
+
+	out := doSimpleRequest("GET", "/tenant/acme/items/7/", nil)
+	if out.Body.String() != "hello 7" {
+		t.Errorf("Unexpected body: %s", out.Body.String())
+	}
+}
+
+func TestURLErrors(t *testing.T) {
+	if _, err := app.URL("missing"); err == nil {
+		t.Error("URL succeeded for a missing route")
+	}
+	if _, err := app.URL("blog", 42); err == nil {
+		t.Error("URL succeeded with too few arguments")
+	}
+	if _, err := app.URL("blog", "abc", "hello"); err == nil {
+		t.Error("URL succeeded with an argument that doesn't match")
+	}
+	if _, err := app.URL("archive-or-tag", 2013, "go"); err == nil {
+		t.Error("URL succeeded with capture groups in several alternatives")
+	}
+	err := app.RouteWithOptions("^other/$", "GET", simpleView1,
+		&uweb.RouteOptions{Name: "blog"})
+	if err == nil {
+		t.Error("Route name was used twice")
+	}
+}
+
+func TestTypedArgs(t *testing.T) {
+	tests := map[string]string{
+		"/typed/41/0.5/true/2013-06-01/": "42 0.50 true Jun 1",
+		"/typed/variadic/1/255/":         "[1 255]",
+		"/typed/color/red/":              "red",
+		"/typed/optional/":               "0",
+		"/typed/optional/5/":             "5",
+	}
+
+	for url, expected := range tests {
+		out := doSimpleRequest("GET", url, nil)
+		if out.Body.String() != expected {
+			t.Errorf("Unexpected body: '%s' != '%s'", expected, out.Body.String())
+		}
+	}
+}
+
+func TestTypedArgErrors(t *testing.T) {
+	tests := map[string]int{
+		"/typed/41/0.5/maybe/2013-06-01/":             404,
+		"/typed/99999999999999999999/1/t/2013-06-01/": 404,
+		"/typed/1/1/t/2013-13-01/":                    404,
+		"/typed/variadic/1/256/":                      404,
+		"/typed/color/pink/":                          404,
+		"/typed/color/purple/":                        400,
+	}
+
+	for url, expected := range tests {
+		out := doSimpleRequest("GET", url, nil)
+		if out.Code != expected {
+			t.Errorf("%s: status code %d != %d", url, out.Code, expected)
+		}
+	}
+}
+
+func TestErrorReturns(t *testing.T) {
+	tests := []struct {
+		url  string
+		code int
+		body string
+	}{
+		{"/errors/value/ok/", 200, `{"Name":"ok"}`},
+		{"/errors/value/status/", 409, "wrapped: status error"},
+		{"/errors/value/response/", 418, "short and stout"},
+		{"/errors/value/plain/", 500, "Internal Server Error"},
+		{"/errors/only/ok/", 200, ""},
+		{"/errors/only/fail/", 403, "status error"},
+	}
+
+	for _, test := range tests {
+		out := doSimpleRequest("GET", test.url, nil)
+		if out.Code != test.code {
+			t.Errorf("%s: status code %d != %d", test.url, out.Code, test.code)
+		}
+		if body := out.Body.String(); test.code == 200 && body != test.body ||
+			test.code != 200 && !strings.Contains(body, test.body) {
+			t.Errorf("%s: unexpected body %s", test.url, body)
+		}
+	}
+
+	out := doSimpleRequest("GET", "/errors/value/plain/", nil)
+	if strings.Contains(out.Body.String(), "hunter2") {
+		t.Error("Message of an internal error shown to the client")
+	}
+}
+
+func TestStructArgs(t *testing.T) {
+	tests := map[string]string{
+		"/users/12/posts/hello-world/":   "12 hello-world false",
+		"/users/12/posts/hello-world/3/": "12 hello-world 3",
+		"/users/12/draft/true/":          "12  false",
+		"/members/7/posts/mounted/":      "7 mounted false",
+		"/posts/5/optional/":             "5 optional false",
+		"/posts/optional/":               "0 optional false",
+	}
+
+	for url, expected := range tests {
+		out := doSimpleRequest("GET", url, nil)
+		if out.Body.String() != expected {
+			t.Errorf("Unexpected body: '%s' != '%s'", expected, out.Body.String())
+		}
+	}
+
+	out := doSimpleRequest("GET", "/users/99999999999999999999/posts/x/", nil)
+	if out.Code != 404 {
+		t.Errorf("Status code %d != 404", out.Code)
+	}
+}
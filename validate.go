@@ -0,0 +1,343 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+/*
+Validate checks the fields of the struct v, or the struct v points to,
+against the rules in their "validate" tags. If any of the fields are invalid
+it returns a 422 *ErrorResponse listing them, otherwise nil.
+
+Struct arguments of a Target are validated after they are bound, so a Target
+only needs to call Validate for values it builds itself.
+
+The rules are separated by commas:
+
+	required     the value must not be empty or zero
+	min=n        numbers must be at least n, strings, slices and maps must
+	             have at least n characters or items
+	max=n        like min, but at most n
+	len=n        strings, slices and maps must have exactly n characters or
+	             items
+	oneof=a b c  the value must be one of those listed
+	email        the value must be an email address
+	pattern=re   strings must match the regular expression, which must be the
+	             last rule as it may contain commas
+
+Rules other than required are not checked for empty strings, slices and maps,
+or nil pointers, but are checked for numbers that are zero. Nested structs,
+and slices of structs, are checked as well.
+
+The rules of a Target's struct arguments are checked when the Target is
+added, which panics if any of them are invalid. Validate panics if it finds
+an invalid rule.
+
+	type CreateUser struct {
+		Name  string `json:"name" validate:"required,max=50"`
+		Email string `json:"email" validate:"required,email"`
+		Role  string `json:"role" validate:"oneof=admin editor viewer"`
+		Age   int    `json:"age" validate:"min=13"`
+	}
+
+A FieldError's Field is the field's name in the request, taken from its
+json, form, query or path tag, and its Rule is the rule that failed.
+*/
+func Validate(v interface{}) error {
+	if fieldErrors := validateValue(reflect.ValueOf(v), "", nil); len(fieldErrors) > 0 {
+		return validationError(fieldErrors)
+	}
+	return nil
+}
+
+func validationError(fieldErrors []FieldError) *ErrorResponse {
+	e := NewError(422, "Unprocessable Entity")
+	e.Fields = fieldErrors
+	return e
+}
+
+// validateValue checks the fields of a struct. Field names are looked up in
+// tags, in order, and prefixed by prefix.
+func validateValue(value reflect.Value, prefix string, tags []string) []FieldError {
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+	if tags == nil {
+		tags = []string{"json", "form", "query", "path"}
+	}
+
+	var fieldErrors []FieldError
+	structType := value.Type()
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue // unexported
+		}
+		fieldValue := value.Field(i)
+		if field.Anonymous && field.Tag.Get("validate") == "" {
+			fieldErrors = append(fieldErrors, validateValue(fieldValue, prefix, tags)...)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := prefix + requestFieldName(field, tags)
+		if rules := field.Tag.Get("validate"); rules != "" && rules != "-" {
+			if rule, message := checkRules(fieldValue, rules); rule != "" {
+				fieldErrors = append(fieldErrors, FieldError{Field: name, Rule: rule, Message: message})
+				continue
+			}
+		}
+
+		// check the fields of nested structs
+		inner := fieldValue
+		for inner.Kind() == reflect.Ptr && !inner.IsNil() {
+			inner = inner.Elem()
+		}
+		switch inner.Kind() {
+		case reflect.Struct:
+			fieldErrors = append(fieldErrors, validateValue(inner, name+".", tags)...)
+		case reflect.Slice, reflect.Array:
+			for j := 0; j < inner.Len(); j++ {
+				itemPrefix := fmt.Sprintf("%s[%d].", name, j)
+				fieldErrors = append(fieldErrors, validateValue(inner.Index(j), itemPrefix, tags)...)
+			}
+		}
+	}
+	return fieldErrors
+}
+
+// requestFieldName returns the name a field is sent with in a request.
+func requestFieldName(field reflect.StructField, tags []string) string {
+	for _, tag := range tags {
+		name := strings.Split(field.Tag.Get(tag), ",")[0]
+		if name != "" && name != "-" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+// checkRules checks a value against the rules in a validate tag, returning
+// the first rule it fails and why.
+func checkRules(value reflect.Value, rules string) (rule, message string) {
+	empty := isEmptyValue(value)
+	missing := empty || value.IsZero()
+	for value.Kind() == reflect.Ptr && !value.IsNil() {
+		value = value.Elem()
+	}
+
+	for _, r := range parseRules(rules) {
+		if r.name == "required" {
+			if missing {
+				return r.name, "is required"
+			}
+			continue
+		}
+		if empty {
+			continue
+		}
+		if message = checkRule(value, r.name, r.param); message != "" {
+			return r.name, message
+		}
+	}
+	return "", ""
+}
+
+// validateRule is one of the rules in a validate tag, such as min=3.
+type validateRule struct {
+	name, param string
+}
+
+func parseRules(rules string) []validateRule {
+	var parsed []validateRule
+	for rules != "" {
+		var r validateRule
+		r.name, rules = splitRule(rules)
+		if i := strings.Index(r.name, "="); i >= 0 {
+			r.name, r.param = r.name[:i], r.name[i+1:]
+		}
+		if r.name == "pattern" && rules != "" {
+			// the rest of the tag is part of the pattern
+			r.param, rules = r.param+","+rules, ""
+		}
+		parsed = append(parsed, r)
+	}
+	return parsed
+}
+
+func splitRule(rules string) (rule, rest string) {
+	if i := strings.Index(rules, ","); i >= 0 {
+		return strings.TrimSpace(rules[:i]), rules[i+1:]
+	}
+	return strings.TrimSpace(rules), ""
+}
+
+// isEmptyValue reports whether a value is a nil pointer or an empty string,
+// slice or map, which only the required rule is checked for.
+func isEmptyValue(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return value.IsNil()
+	case reflect.String, reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	}
+	return false
+}
+
+// checkValidateTags checks the rules in the validate tags of a struct type,
+// and of the structs nested in it, so that a mistake in them is found when a
+// target is added rather than when it is called.
+func checkValidateTags(t reflect.Type) error {
+	return checkStructTags(t, make(map[reflect.Type]bool))
+}
+
+func checkStructTags(t reflect.Type, seen map[reflect.Type]bool) error {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || seen[t] {
+		return nil
+	}
+	seen[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue // unexported
+		}
+		if rules := field.Tag.Get("validate"); rules != "" && rules != "-" {
+			for _, r := range parseRules(rules) {
+				if err := checkRuleType(field.Type, r); err != nil {
+					return fmt.Errorf("field %s: %v", field.Name, err)
+				}
+			}
+		}
+		if err := checkStructTags(field.Type, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRuleType returns an error if a rule is invalid or can't be used with
+// values of type t.
+func checkRuleType(t reflect.Type, r validateRule) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch r.name {
+	case "required", "oneof", "email":
+		return nil
+	case "min", "max", "len":
+		if _, err := strconv.ParseFloat(r.param, 64); err != nil {
+			return fmt.Errorf("invalid validation rule %s=%s", r.name, r.param)
+		}
+		switch t.Kind() {
+		case reflect.String, reflect.Slice, reflect.Map, reflect.Array,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			return nil
+		}
+		return fmt.Errorf("validation rule %s can't be used with %s", r.name, t)
+	case "pattern":
+		if _, err := regexp.Compile(r.param); err != nil {
+			return fmt.Errorf("invalid validation rule pattern=%s: %v", r.param, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown validation rule %s", r.name)
+}
+
+// checkRule checks a value against a single rule, returning why it fails or
+// "" if it passes. It panics if the rule is invalid.
+func checkRule(value reflect.Value, rule, param string) string {
+	switch rule {
+	case "min", "max", "len":
+		limit, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			panic(fmt.Sprintf("Invalid validation rule %s=%s", rule, param))
+		}
+		return checkSize(value, rule, limit, param)
+	case "oneof":
+		s := fmt.Sprint(value.Interface())
+		options := strings.Fields(param)
+		for _, option := range options {
+			if s == option {
+				return ""
+			}
+		}
+		return "must be one of " + strings.Join(options, ", ")
+	case "email":
+		s := fmt.Sprint(value.Interface())
+		if addr, err := mail.ParseAddress(s); err != nil || addr.Address != s {
+			return "must be a valid email address"
+		}
+		return ""
+	case "pattern":
+		if !compilePattern(param).MatchString(fmt.Sprint(value.Interface())) {
+			return "must match the pattern " + param
+		}
+		return ""
+	}
+	panic(fmt.Sprintf("Unknown validation rule %s", rule))
+}
+
+// checkSize checks the min, max and len rules.
+func checkSize(value reflect.Value, rule string, limit float64, param string) string {
+	var size float64
+	verb, unit := "be", ""
+	switch value.Kind() {
+	case reflect.String:
+		size, unit = float64(utf8.RuneCountInString(value.String())), " characters long"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		size, verb, unit = float64(value.Len()), "have", " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		size = float64(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		size = float64(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		size = value.Float()
+	default:
+		panic(fmt.Sprintf("Validation rule %s can't be used with %s", rule, value.Type()))
+	}
+
+	switch {
+	case rule == "min" && size < limit:
+		return fmt.Sprintf("must %s at least %s%s", verb, param, unit)
+	case rule == "max" && size > limit:
+		return fmt.Sprintf("must %s at most %s%s", verb, param, unit)
+	case rule == "len" && size != limit:
+		return fmt.Sprintf("must %s exactly %s%s", verb, param, unit)
+	}
+	return ""
+}
+
+var patterns sync.Map
+
+func compilePattern(pattern string) *regexp.Regexp {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	re := regexp.MustCompile(pattern)
+	patterns.Store(pattern, re)
+	return re
+}
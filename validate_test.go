@@ -0,0 +1,174 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb_test
+
+import (
+	"github.com/calebbrown/uweb"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type address struct {
+	Street string `json:"street" validate:"required"`
+	Zip    string `json:"zip" validate:"pattern=^[0-9]{4,5}$"`
+}
+
+type signup struct {
+	Name      string    `json:"name" form:"full_name" validate:"required,min=2,max=10"`
+	Email     string    `json:"email" validate:"required,email"`
+	Role      string    `json:"role" validate:"oneof=admin editor viewer"`
+	Age       int       `json:"age" validate:"min=13,max=120"`
+	Nickname  *string   `json:"nickname" validate:"len=3"`
+	Tags      []string  `json:"tags" validate:"max=2"`
+	Address   address   `json:"address"`
+	Previous  []address `json:"previous"`
+	Reference string    `json:"reference" validate:"pattern=^[A-Z]{2,3}-[0-9]+$"`
+}
+
+func fieldErrorsOf(err error) []uweb.FieldError {
+	if err == nil {
+		return nil
+	}
+	e := err.(*uweb.ErrorResponse)
+	if e.StatusCode() != 422 {
+		panic("unexpected status code")
+	}
+	return e.Fields
+}
+
+func TestValidate(t *testing.T) {
+	short := "ab"
+	valid := signup{
+		Name:      "Bob",
+		Email:     "bob@example.com",
+		Age:       30,
+		Address:   address{Street: "1 Main St", Zip: "1234"},
+		Reference: "AB-12",
+	}
+	if err := uweb.Validate(&valid); err != nil {
+		t.Errorf("Unexpected error for a valid value: %v", fieldErrorsOf(err))
+	}
+
+	invalid := signup{
+		Name:      "B",
+		Email:     "Bob <bob@example.com>",
+		Role:      "owner",
+		Age:       12,
+		Nickname:  &short,
+		Tags:      []string{"a", "b", "c"},
+		Previous:  []address{{Street: "2 Main St", Zip: "1"}},
+		Reference: "A-1",
+	}
+	expected := []uweb.FieldError{
+		{Field: "name", Rule: "min", Message: "must be at least 2 characters long"},
+		{Field: "email", Rule: "email", Message: "must be a valid email address"},
+		{Field: "role", Rule: "oneof", Message: "must be one of admin, editor, viewer"},
+		{Field: "age", Rule: "min", Message: "must be at least 13"},
+		{Field: "nickname", Rule: "len", Message: "must be exactly 3 characters long"},
+		{Field: "tags", Rule: "max", Message: "must have at most 2 items"},
+		{Field: "address.street", Rule: "required", Message: "is required"},
+		{Field: "previous[0].zip", Rule: "pattern", Message: "must match the pattern ^[0-9]{4,5}$"},
+		{Field: "reference", Rule: "pattern", Message: "must match the pattern ^[A-Z]{2,3}-[0-9]+$"},
+	}
+	fieldErrors := fieldErrorsOf(uweb.Validate(invalid))
+	if !reflect.DeepEqual(fieldErrors, expected) {
+		t.Errorf("Unexpected field errors:\n%v\n%v", fieldErrors, expected)
+	}
+}
+
+func TestValidateBound(t *testing.T) {
+	a := uweb.NewApp()
+	a.Post("^signup/$", func(in signup) string {
+		return "welcome " + in.Name
+	})
+	a.Error(422, func(e *uweb.ErrorResponse) []uweb.FieldError {
+		return e.Fields
+	})
+
+	out := serve(a, "POST", "/signup/",
+		strings.NewReader(`{"name": "Bob", "email": "bob@example.com", "age": 30, "address": {"street": "x"}, "reference": "AB-1"}`),
+		map[string]string{"Content-Type": "application/json"})
+	if out.Code != 200 || out.Body.String() != "welcome Bob" {
+		t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+	}
+
+	out = serve(a, "POST", "/signup/", strings.NewReader(`{"name": "Bob", "age": 200}`),
+		map[string]string{"Content-Type": "application/json"})
+	expected := `[{"field":"email","rule":"required","message":"is required"},` +
+		`{"field":"age","rule":"max","message":"must be at most 120"},` +
+		`{"field":"address.street","rule":"required","message":"is required"}]`
+	if out.Code != 422 || strings.TrimSpace(out.Body.String()) != expected {
+		t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+	}
+
+	// fields are named as they were sent
+	out = serve(a, "POST", "/signup/", strings.NewReader("email=x"),
+		map[string]string{"Content-Type": "application/x-www-form-urlencoded"})
+	if out.Code != 422 || !strings.HasPrefix(out.Body.String(), `[{"field":"full_name","rule":"required"`) {
+		t.Errorf("Unexpected response: %d %s", out.Code, out.Body.String())
+	}
+}
+
+func TestValidateZero(t *testing.T) {
+	type counts struct {
+		Age   int  `validate:"min=13"`
+		Count *int `validate:"required,max=5"`
+		Ratio float64
+	}
+	zero := 0
+	expected := []uweb.FieldError{{Field: "Age", Rule: "min", Message: "must be at least 13"}}
+	if fieldErrors := fieldErrorsOf(uweb.Validate(counts{Count: &zero})); !reflect.DeepEqual(fieldErrors, expected) {
+		t.Errorf("Unexpected field errors: %v", fieldErrors)
+	}
+	expected = []uweb.FieldError{{Field: "Count", Rule: "required", Message: "is required"}}
+	if fieldErrors := fieldErrorsOf(uweb.Validate(counts{Age: 13})); !reflect.DeepEqual(fieldErrors, expected) {
+		t.Errorf("Unexpected field errors: %v", fieldErrors)
+	}
+}
+
+func TestValidateRulesCheckedOnRegistration(t *testing.T) {
+	targets := []interface{}{
+		func(in struct {
+			Name string `validate:"unknown"`
+		}) {
+		},
+		func(in *struct {
+			Age int `validate:"min=x"`
+		}) {
+		},
+		func(in struct {
+			Admin bool `validate:"max=1"`
+		}) {
+		},
+		func(in struct {
+			Items []struct {
+				Code string `validate:"pattern=("`
+			}
+		}) {
+		},
+	}
+	for i, target := range targets {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Target %d with an invalid rule added", i)
+				}
+			}()
+			uweb.NewApp().Post("^x/$", target)
+		}()
+	}
+}
+
+func TestValidateInvalidRule(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Unknown rule didn't panic")
+		}
+	}()
+	uweb.Validate(struct {
+		Name string `validate:"unknown"`
+	}{Name: "x"})
+}
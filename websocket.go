@@ -0,0 +1,488 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// WebSocket message types, from RFC 6455 section 5.2.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+
+	continuationFrame = 0
+)
+
+// WebSocket close codes, from RFC 6455 section 7.4.1.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseAbnormal        = 1006
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// ErrWebSocketClosed is returned when writing to a closed WebSocketConn.
+var ErrWebSocketClosed = errors.New("websocket: connection closed")
+
+// A CloseError is returned by ReadMessage when the connection is closed.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed %d %s", e.Code, e.Reason)
+}
+
+// WebSocketOptions holds the settings for a WebSocket route.
+//
+// CheckOrigin reports whether a handshake request is allowed. By default
+// requests with an Origin header must come from the same host.
+//
+// Subprotocols lists the subprotocols the server supports, in order of
+// preference. The first one also requested by the client is chosen.
+//
+// MaxMessageSize limits the size of received messages, the connection is
+// closed if a larger message is received. It defaults to 32MB.
+//
+// FragmentSize splits sent messages larger than it into several frames. By
+// default messages are sent as a single frame.
+//
+// The RouteOptions apply to the route as they do for RouteWithOptions.
+type WebSocketOptions struct {
+	RouteOptions
+	CheckOrigin    func(ctx *Context) bool
+	Subprotocols   []string
+	MaxMessageSize int64
+	FragmentSize   int
+}
+
+// WebSocketConn is a WebSocket connection passed to a WebSocket target.
+//
+// ReadMessage must only be called from one goroutine at a time. The write
+// methods may be called concurrently.
+type WebSocketConn struct {
+	// Subprotocol is the subprotocol chosen during the handshake.
+	Subprotocol string
+
+	conn           net.Conn
+	br             *bufio.Reader
+	maxMessageSize int64
+	fragmentSize   int
+
+	writeLock sync.Mutex
+	bw        *bufio.Writer
+	closeSent bool
+}
+
+// ReadMessage reads the next text or binary message, putting together
+// fragmented messages.
+//
+// Pings are answered, and pongs ignored, while waiting for a message. When
+// the client closes the connection, or breaks the protocol, a *CloseError is
+// returned.
+func (c *WebSocketConn) ReadMessage() (messageType int, data []byte, err error) {
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch op {
+		case PingMessage:
+			if err := c.writeFrame(true, PongMessage, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			continue
+		case CloseMessage:
+			return 0, nil, c.closed(payload)
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				return 0, nil, c.fail(CloseProtocolError, "expected a continuation frame")
+			}
+			messageType = op
+			data = payload
+		case continuationFrame:
+			if messageType == 0 {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+			data = append(data, payload...)
+		default:
+			return 0, nil, c.fail(CloseProtocolError, "unknown opcode")
+		}
+
+		if int64(len(data)) > c.maxMessageSize {
+			return 0, nil, c.fail(CloseMessageTooBig, "message too big")
+		}
+		if fin {
+			if messageType == TextMessage && !utf8.Valid(data) {
+				return 0, nil, c.fail(CloseInvalidPayload, "invalid UTF-8")
+			}
+			return messageType, data, nil
+		}
+	}
+}
+
+// readFrame reads a single frame and unmasks its payload.
+func (c *WebSocketConn) readFrame() (fin bool, op int, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(c.br, header[:]); err != nil {
+		return
+	}
+	fin = header[0]&0x80 != 0
+	op = int(header[0] & 0x0f)
+	masked := header[1]&0x80 != 0
+	length := int64(header[1] & 0x7f)
+
+	if header[0]&0x70 != 0 {
+		return fin, op, nil, c.fail(CloseProtocolError, "reserved bits set")
+	}
+	if !masked {
+		return fin, op, nil, c.fail(CloseProtocolError, "frame not masked")
+	}
+	if op >= CloseMessage && (!fin || length > 125) {
+		return fin, op, nil, c.fail(CloseProtocolError, "invalid control frame")
+	}
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+	if length < 0 || length > c.maxMessageSize {
+		return fin, op, nil, c.fail(CloseMessageTooBig, "message too big")
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// WriteMessage sends a text or binary message.
+func (c *WebSocketConn) WriteMessage(messageType int, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", messageType)
+	}
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	op := messageType
+	for c.fragmentSize > 0 && len(data) > c.fragmentSize {
+		if err := c.writeFrameLocked(false, op, data[:c.fragmentSize]); err != nil {
+			return err
+		}
+		op = continuationFrame
+		data = data[c.fragmentSize:]
+	}
+	return c.writeFrameLocked(true, op, data)
+}
+
+// WriteText sends a text message.
+func (c *WebSocketConn) WriteText(s string) error {
+	return c.WriteMessage(TextMessage, []byte(s))
+}
+
+// Ping sends a ping. The client's pong is ignored by ReadMessage.
+func (c *WebSocketConn) Ping(data []byte) error {
+	return c.writeFrame(true, PingMessage, data)
+}
+
+// Close sends a close frame with the code and reason and closes the
+// connection.
+func (c *WebSocketConn) Close(code int, reason string) error {
+	err := c.sendClose(code, reason)
+	c.conn.Close()
+	return err
+}
+
+// SetReadDeadline sets the deadline for reading from the connection.
+func (c *WebSocketConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for writing to the connection.
+func (c *WebSocketConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// RemoteAddr returns the address of the client.
+func (c *WebSocketConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *WebSocketConn) writeFrame(fin bool, op int, payload []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return c.writeFrameLocked(fin, op, payload)
+}
+
+func (c *WebSocketConn) writeFrameLocked(fin bool, op int, payload []byte) error {
+	if c.closeSent {
+		return ErrWebSocketClosed
+	}
+	if op == CloseMessage {
+		c.closeSent = true
+	}
+
+	b0 := byte(op)
+	if fin {
+		b0 |= 0x80
+	}
+	c.bw.WriteByte(b0)
+
+	length := len(payload)
+	switch {
+	case length <= 125:
+		c.bw.WriteByte(byte(length))
+	case length <= 0xffff:
+		var ext [2]byte
+		binary.BigEndian.PutUint16(ext[:], uint16(length))
+		c.bw.WriteByte(126)
+		c.bw.Write(ext[:])
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(length))
+		c.bw.WriteByte(127)
+		c.bw.Write(ext[:])
+	}
+	c.bw.Write(payload)
+	return c.bw.Flush()
+}
+
+func (c *WebSocketConn) sendClose(code int, reason string) error {
+	var payload []byte
+	if code != CloseNoStatus {
+		payload = make([]byte, 2, 2+len(reason))
+		binary.BigEndian.PutUint16(payload, uint16(code))
+		payload = append(payload, reason...)
+	}
+	return c.writeFrame(true, CloseMessage, payload)
+}
+
+// closed handles a close frame from the client, replying with the same code
+// if a close frame hasn't already been sent.
+func (c *WebSocketConn) closed(payload []byte) error {
+	e := &CloseError{Code: CloseNoStatus}
+	if len(payload) == 1 {
+		return c.fail(CloseProtocolError, "invalid close frame")
+	}
+	if len(payload) >= 2 {
+		e.Code = int(binary.BigEndian.Uint16(payload))
+		e.Reason = string(payload[2:])
+		if !utf8.ValidString(e.Reason) {
+			return c.fail(CloseInvalidPayload, "invalid UTF-8")
+		}
+	}
+	c.sendClose(e.Code, "")
+	c.conn.Close()
+	return e
+}
+
+// fail closes the connection because the client broke the protocol.
+func (c *WebSocketConn) fail(code int, reason string) error {
+	c.Close(code, reason)
+	return &CloseError{Code: code, Reason: reason}
+}
+
+// headerContains reports whether the comma separated header contains token,
+// ignoring case.
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sameOrigin reports whether the request's Origin, if it has one, is the
+// host the request was sent to.
+func sameOrigin(ctx *Context) bool {
+	origin := ctx.Request.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, ctx.Request.Host)
+}
+
+// upgrade performs the WebSocket handshake, aborting the request if it isn't
+// a valid handshake.
+func upgrade(ctx *Context, options *WebSocketOptions) *WebSocketConn {
+	r := ctx.Request
+	if r.Method != "GET" {
+		Abort(405, "Method not allowed")
+	}
+	if !headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		ctx.Response.Header().Set("Upgrade", "websocket")
+		ctx.Response.Header().Set("Connection", "Upgrade")
+		Abort(426, "Upgrade Required")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		ctx.Response.Header().Set("Sec-WebSocket-Version", "13")
+		Abort(426, "Upgrade Required")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		Abort(400, "Bad Request")
+	}
+	checkOrigin := options.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(ctx) {
+		Abort(403, "Forbidden")
+	}
+
+	subprotocol := ""
+	for _, p := range options.Subprotocols {
+		if headerContains(r.Header, "Sec-WebSocket-Protocol", p) {
+			subprotocol = p
+			break
+		}
+	}
+
+	hijacker, ok := ctx.Writer.(http.Hijacker)
+	if !ok {
+		panic("WebSocket requires a http.ResponseWriter that supports hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		panic(err)
+	}
+	ctx.Response.written = true
+
+	h := sha1.New()
+	h.Write([]byte(key + webSocketGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(h.Sum(nil)) + "\r\n")
+	if subprotocol != "" {
+		rw.WriteString("Sec-WebSocket-Protocol: " + subprotocol + "\r\n")
+	}
+	rw.WriteString("\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		panic(err)
+	}
+
+	maxMessageSize := options.MaxMessageSize
+	if maxMessageSize <= 0 {
+		maxMessageSize = 32 << 20
+	}
+	return &WebSocketConn{
+		Subprotocol:    subprotocol,
+		conn:           conn,
+		br:             rw.Reader,
+		bw:             rw.Writer,
+		maxMessageSize: maxMessageSize,
+		fragmentSize:   options.FragmentSize,
+	}
+}
+
+func argIsWebSocket(argType reflect.Type) bool {
+	return argType == reflect.TypeOf(&WebSocketConn{})
+}
+
+/*
+WebSocket maps a function to a url pattern for WebSocket connections.
+
+The target is called once the handshake has completed, and receives the
+connection after the Context. The other arguments are filled in from the url
+pattern the same way as for other targets. The connection is closed when the
+target returns, and the target's return value is ignored.
+
+	app.WebSocket("^chat/([a-z]+)/$", func(ctx *uweb.Context, ws *uweb.WebSocketConn, room string) {
+		for {
+			_, msg, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			ws.WriteText(room + ": " + string(msg))
+		}
+	})
+*/
+func (a *App) WebSocket(pattern string, target Target) error {
+	return a.WebSocketWithOptions(pattern, target, nil)
+}
+
+// Map a function to a url pattern for WebSocket connections, using the
+// options for the handshake and the connection.
+func (a *App) WebSocketWithOptions(pattern string, target Target, options *WebSocketOptions) error {
+	if options == nil {
+		options = &WebSocketOptions{}
+	}
+	callable := wrapTarget(target)
+
+	handler := func(ctx *Context) *Response {
+		ws := upgrade(ctx, options)
+		defer func() {
+			if err := recover(); err != nil {
+				ws.Close(CloseInternalError, "")
+				panic(err)
+			}
+			ws.Close(CloseNormal, "")
+		}()
+		ctx.webSocket = ws
+		callable(ctx, ctx.Args...)
+		return ctx.Response
+	}
+
+	var routeOptions *RouteOptions
+	if !reflect.DeepEqual(options.RouteOptions, RouteOptions{}) {
+		routeOptions = &options.RouteOptions
+	}
+	return a.addRoute(pattern, "GET", handler, routeOptions)
+}
@@ -0,0 +1,244 @@
+// Copyright 2013 Caleb Brown. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uweb_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/calebbrown/uweb"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// wsClient is a minimal WebSocket client for testing.
+type wsClient struct {
+	conn net.Conn
+	br   *bufio.Reader
+	resp *http.Response
+}
+
+func dialWebSocket(t *testing.T, server *httptest.Server, path string, header map[string]string) *wsClient {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", server.URL+path, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	req.Write(conn)
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &wsClient{conn: conn, br: br, resp: resp}
+}
+
+func (c *wsClient) writeFrame(fin bool, op int, payload []byte) {
+	b0 := byte(op)
+	if fin {
+		b0 |= 0x80
+	}
+	frame := []byte{b0}
+	switch {
+	case len(payload) <= 125:
+		frame = append(frame, 0x80|byte(len(payload)))
+	default:
+		frame = append(frame, 0x80|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	c.conn.Write(frame)
+}
+
+func (c *wsClient) readFrame() (fin bool, op int, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(c.br, header[:]); err != nil {
+		return
+	}
+	length := int(header[1] & 0x7f)
+	if length == 126 {
+		var ext [2]byte
+		io.ReadFull(c.br, ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload = make([]byte, length)
+	_, err = io.ReadFull(c.br, payload)
+	return header[0]&0x80 != 0, int(header[0] & 0x0f), payload, err
+}
+
+func newWebSocketServer() *httptest.Server {
+	a := uweb.NewApp()
+	a.WebSocketWithOptions("^echo/([a-z]+)/$", func(ctx *uweb.Context, ws *uweb.WebSocketConn, prefix string) {
+		for {
+			messageType, msg, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			if string(msg) == "bye" {
+				ws.Close(uweb.CloseGoingAway, "bye")
+				return
+			}
+			ws.WriteMessage(messageType, append([]byte(prefix+":"), msg...))
+		}
+	}, &uweb.WebSocketOptions{
+		Subprotocols:   []string{"chat", "echo"},
+		MaxMessageSize: 1024,
+		FragmentSize:   4,
+	})
+	a.WebSocket("^once/$", func(ws *uweb.WebSocketConn) {
+		ws.WriteText(ws.Subprotocol)
+	})
+	return httptest.NewServer(a)
+}
+
+func TestWebSocketHandshake(t *testing.T) {
+	server := newWebSocketServer()
+	defer server.Close()
+
+	c := dialWebSocket(t, server, "/once/", nil)
+	defer c.conn.Close()
+	if c.resp.StatusCode != 101 {
+		t.Fatalf("Status code %d != 101", c.resp.StatusCode)
+	}
+	// example key and accept value from RFC 6455
+	if accept := c.resp.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Unexpected Sec-WebSocket-Accept: %s", accept)
+	}
+
+	_, op, payload, _ := c.readFrame()
+	if op != uweb.TextMessage || len(payload) != 0 {
+		t.Errorf("Unexpected message: %d %q", op, payload)
+	}
+	_, op, payload, _ = c.readFrame()
+	if op != uweb.CloseMessage || binary.BigEndian.Uint16(payload) != uweb.CloseNormal {
+		t.Errorf("Expected a normal close: %d %v", op, payload)
+	}
+}
+
+func TestWebSocketHandshakeErrors(t *testing.T) {
+	server := newWebSocketServer()
+	defer server.Close()
+
+	tests := []struct {
+		header map[string]string
+		code   int
+	}{
+		{map[string]string{"Upgrade": "h2c"}, 426},
+		{map[string]string{"Sec-WebSocket-Version": "8"}, 426},
+		{map[string]string{"Sec-WebSocket-Key": "short"}, 400},
+		{map[string]string{"Origin": "http://evil.example.com"}, 403},
+	}
+	for _, test := range tests {
+		c := dialWebSocket(t, server, "/once/", test.header)
+		c.conn.Close()
+		if c.resp.StatusCode != test.code {
+			t.Errorf("%v: status code %d != %d", test.header, c.resp.StatusCode, test.code)
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/once/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 426 || resp.Header.Get("Upgrade") != "websocket" {
+		t.Errorf("Unexpected response to a plain GET: %d %v", resp.StatusCode, resp.Header)
+	}
+}
+
+func TestWebSocketMessages(t *testing.T) {
+	server := newWebSocketServer()
+	defer server.Close()
+
+	c := dialWebSocket(t, server, "/echo/abc/", map[string]string{
+		"Sec-WebSocket-Protocol": "echo, chat",
+		"Origin":                 server.URL,
+	})
+	defer c.conn.Close()
+	if c.resp.StatusCode != 101 {
+		t.Fatalf("Status code %d != 101", c.resp.StatusCode)
+	}
+	if p := c.resp.Header.Get("Sec-WebSocket-Protocol"); p != "chat" {
+		t.Errorf("Unexpected subprotocol: %s", p)
+	}
+
+	// a fragmented message with a ping in the middle
+	c.writeFrame(false, uweb.TextMessage, []byte("hel"))
+	c.writeFrame(true, uweb.PingMessage, []byte("ping"))
+	c.writeFrame(true, 0, []byte("lo"))
+
+	fin, op, payload, _ := c.readFrame()
+	if !fin || op != uweb.PongMessage || string(payload) != "ping" {
+		t.Errorf("Unexpected pong: %t %d %q", fin, op, payload)
+	}
+
+	// the reply is sent in fragments of 4 bytes
+	var message []byte
+	for i := 0; ; i++ {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (i == 0 && op != uweb.TextMessage) || (i > 0 && op != 0) {
+			t.Errorf("Unexpected opcode %d for fragment %d", op, i)
+		}
+		message = append(message, payload...)
+		if fin {
+			break
+		}
+	}
+	if string(message) != "abc:hello" {
+		t.Errorf("Unexpected message: %q", message)
+	}
+
+	c.writeFrame(true, uweb.TextMessage, []byte("bye"))
+	_, op, payload, _ = c.readFrame()
+	if op != uweb.CloseMessage || binary.BigEndian.Uint16(payload) != uweb.CloseGoingAway ||
+		string(payload[2:]) != "bye" {
+		t.Errorf("Unexpected close: %d %q", op, payload)
+	}
+}
+
+func TestWebSocketProtocolErrors(t *testing.T) {
+	server := newWebSocketServer()
+	defer server.Close()
+
+	tests := []struct {
+		op      int
+		payload []byte
+		code    uint16
+	}{
+		{uweb.TextMessage, []byte{0xff, 0xfe}, uweb.CloseInvalidPayload},
+		{3, []byte("unknown"), uweb.CloseProtocolError},
+		{0, []byte("continuation"), uweb.CloseProtocolError},
+		{uweb.BinaryMessage, make([]byte, 2000), uweb.CloseMessageTooBig},
+		{uweb.CloseMessage, []byte{0x03, 0xe8}, uweb.CloseNormal},
+	}
+	for _, test := range tests {
+		c := dialWebSocket(t, server, "/echo/abc/", nil)
+		c.writeFrame(true, test.op, test.payload)
+		_, op, payload, _ := c.readFrame()
+		if op != uweb.CloseMessage || len(payload) < 2 ||
+			binary.BigEndian.Uint16(payload) != test.code {
+			t.Errorf("Opcode %d: unexpected close %d %v", test.op, op, payload)
+		}
+		c.conn.Close()
+	}
+}